@@ -2,32 +2,87 @@ package elrpc
 
 import (
 	"fmt"
+	"math/big"
 	"reflect"
 
 	"github.com/kiwanami/go-elrpc/parser"
 )
 
 func Decode(sexp string) ([]interface{}, error) {
+	return DecodeWith(sexp, nil)
+}
+
+// DecodeWith decodes S-expressions into Go objects with the options.
+func DecodeWith(sexp string, o *parser.ValueOptions) ([]interface{}, error) {
 	sexps, err := DecodeToSExp(sexp)
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]interface{}, len(sexp))
-	for i, sexp := range sexps {
-		ret[i] = sexp.ToValue()
-	}
-	return ret, nil
+	return decodeAll(sexps, o)
 }
 
 func Decode1(sexp string) (interface{}, error) {
+	return Decode1With(sexp, nil)
+}
+
+// Decode1With decodes the first S-expression into a Go object with the options.
+func Decode1With(sexp string, o *parser.ValueOptions) (interface{}, error) {
 	sexps, err := DecodeToSExp(sexp)
 	if err != nil {
 		return nil, err
 	}
+	return decodeFirst(sexps, o)
+}
+
+// DecodeBytes decodes S-expressions in the bytes without copying them
+// into a string. The result does not share the memory with b.
+func DecodeBytes(b []byte) ([]interface{}, error) {
+	return DecodeBytesWith(b, nil)
+}
+
+// DecodeBytesWith is DecodeBytes with the options.
+func DecodeBytesWith(b []byte, o *parser.ValueOptions) ([]interface{}, error) {
+	sexps, err := parser.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeAll(sexps, o)
+}
+
+// Decode1Bytes decodes the first S-expression in the bytes without
+// copying them into a string. The result does not share the memory with b.
+func Decode1Bytes(b []byte) (interface{}, error) {
+	return Decode1BytesWith(b, nil)
+}
+
+// Decode1BytesWith is Decode1Bytes with the options.
+func Decode1BytesWith(b []byte, o *parser.ValueOptions) (interface{}, error) {
+	sexps, err := parser.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeFirst(sexps, o)
+}
+
+func decodeAll(sexps []parser.SExp, o *parser.ValueOptions) ([]interface{}, error) {
+	if err := parser.CheckCycles(sexps); err != nil {
+		return nil, err
+	}
+	ret := make([]interface{}, len(sexps))
+	for i, sexp := range sexps {
+		ret[i] = sexp.ToValueWith(o)
+	}
+	return ret, nil
+}
+
+func decodeFirst(sexps []parser.SExp, o *parser.ValueOptions) (interface{}, error) {
 	if len(sexps) == 0 {
 		return nil, nil
 	}
-	return sexps[0].ToValue(), nil
+	if err := parser.CheckCycles(sexps[:1]); err != nil {
+		return nil, err
+	}
+	return sexps[0].ToValueWith(o), nil
 }
 
 func DecodeToSExp(sexp string) ([]parser.SExp, error) {
@@ -48,7 +103,66 @@ func ToArray(o interface{}) []interface{} {
 	return arr
 }
 
+// AlistToMap converts an alist into a map. The keys should be strings,
+// symbols or keywords ("key", Symbol("key") and Keyword("key") give "key",
+// "key" and ":key"). The value of (key . value) is the cdr, which is a
+// Cons when decoded with the PreserveCons option, or a 2-element slice
+// otherwise. Without the option, the slice (key v1 v2 ...) is taken as
+// (key . (v1 v2 ...)), because it can not be distinguished from a dotted
+// pair.
+func AlistToMap(o interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{})
+	if o == nil {
+		return ret, nil // empty alist
+	}
+	lst := reflect.ValueOf(o)
+	if lst.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("not an alist: %v", o)
+	}
+	for i := 0; i < lst.Len(); i++ {
+		var key, value interface{}
+		switch pair := lst.Index(i).Interface().(type) {
+		case Cons:
+			key, value = pair.Car, pair.Cdr
+		case *Cons:
+			key, value = pair.Car, pair.Cdr
+		default:
+			pv := reflect.ValueOf(pair)
+			if pv.Kind() != reflect.Slice || pv.Len() < 2 {
+				return nil, fmt.Errorf("not a key-value pair: %v", pair)
+			}
+			key = pv.Index(0).Interface()
+			if pv.Len() == 2 {
+				value = pv.Index(1).Interface()
+			} else {
+				value = pv.Slice(1, pv.Len()).Interface()
+			}
+		}
+		name, ok := symbolName(key)
+		if !ok {
+			return nil, fmt.Errorf("invalid alist key: %v", key)
+		}
+		if _, dup := ret[name]; !dup {
+			ret[name] = value // the first one wins like assoc
+		}
+	}
+	return ret, nil
+}
+
+var bigIntPtrType = reflect.TypeOf((*big.Int)(nil))
+
 func ConvertType(targetType reflect.Type, srcValue reflect.Value) (reflect.Value, error) {
+	if srcValue.IsValid() && srcValue.Type() == bigIntPtrType && targetType != bigIntPtrType {
+		return ConvertBigIntType(targetType, srcValue.Interface().(*big.Int))
+	}
+	if targetType == bigIntPtrType && srcValue.IsValid() {
+		switch srcValue.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return reflect.ValueOf(big.NewInt(srcValue.Int())), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return reflect.ValueOf(new(big.Int).SetUint64(srcValue.Uint())), nil
+		}
+	}
 	switch targetType.Kind() {
 	case reflect.Slice:
 		fallthrough
@@ -60,6 +174,34 @@ func ConvertType(targetType reflect.Type, srcValue reflect.Value) (reflect.Value
 	return srcValue.Convert(targetType), nil
 }
 
+// ConvertBigIntType converts a bignum into the target type, failing when
+// the value overflows the target.
+func ConvertBigIntType(targetType reflect.Type, bi *big.Int) (reflect.Value, error) {
+	ret := reflect.New(targetType).Elem()
+	switch targetType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if bi.IsInt64() && !ret.OverflowInt(bi.Int64()) {
+			ret.SetInt(bi.Int64())
+			return ret, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if bi.IsUint64() && !ret.OverflowUint(bi.Uint64()) {
+			ret.SetUint(bi.Uint64())
+			return ret, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		f, _ := new(big.Float).SetInt(bi).Float64()
+		ret.SetFloat(f)
+		return ret, nil
+	case reflect.Interface:
+		ret.Set(reflect.ValueOf(bi))
+		return ret, nil
+	default:
+		return ret, fmt.Errorf("converting bignum to [%v] not implemented", targetType.String())
+	}
+	return ret, fmt.Errorf("bignum %v overflows [%v]", bi, targetType.String())
+}
+
 func convertElm(lst reflect.Value, i int, elmType reflect.Type) reflect.Value {
 	cv, _ := ConvertType(elmType, reflect.ValueOf(lst.Index(i).Interface()))
 	return cv
@@ -98,6 +240,11 @@ func ConvertArrayType(targetType reflect.Type, srcValue reflect.Value) (reflect.
 			cv := convertElm(srcValue, i, elmType)
 			retSliceVal.Index(i).SetFloat(cv.Float())
 		}
+	case reflect.Bool:
+		for i := 0; i < len; i++ {
+			cv := convertElm(srcValue, i, elmType)
+			retSliceVal.Index(i).SetBool(cv.Bool())
+		}
 	case reflect.Array:
 		for i := 0; i < len; i++ {
 			cv := convertElm(srcValue, i, elmType)
@@ -1,8 +1,12 @@
 package elrpc
 
 import (
+	"bytes"
 	"fmt"
+	"math"
+	"math/big"
 	"testing"
+	"testing/quick"
 
 	"reflect"
 
@@ -94,6 +98,23 @@ func TestPrimitives1(t *testing.T) {
 	}
 }
 
+func TestNumbers1(t *testing.T) {
+	data := map[string]srcdata{
+		"int dot":      srcdata{"1.", 1},
+		"float":        srcdata{"2.0", 2.0},
+		"exponent":     srcdata{"1e+06", 1e6},
+		"inf":          srcdata{"1.0e+INF", math.Inf(1)},
+		"minus inf":    srcdata{"-1.0e+INF", math.Inf(-1)},
+		"nan":          srcdata{"0.0e+NaN", math.NaN()},
+		"float list":   srcdata{"(1.0 1.0e+INF)", []float64{1.0, math.Inf(1)}},
+		"int symbol":   srcdata{"(1+ 1)", []interface{}{"1+", 1}},
+		"int dot list": srcdata{"(1. 2.)", []int{1, 2}},
+	}
+	for k, v := range data {
+		testDecodeObject(t, k, v.src, v.exp)
+	}
+}
+
 /// type conversion
 
 type srcconv struct {
@@ -170,3 +191,210 @@ func TestConvertDoubleArray1(t *testing.T) {
 		}
 	}
 }
+
+func TestBignum1(t *testing.T) {
+	src := "(1 123456789012345678901234567890 -98765432109876543210)"
+	res, err := Decode1(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr := ToArray(res)
+	if len(arr) != 3 || arr[0] != 1 {
+		t.Fatalf("wrong bignum list: %v", res)
+	}
+	exps := []string{"123456789012345678901234567890", "-98765432109876543210"}
+	for i, exp := range exps {
+		bi, ok := arr[i+1].(*big.Int)
+		if !ok || bi.String() != exp {
+			t.Errorf("expected bignum [%s] but returned [%v]", exp, arr[i+1])
+		}
+	}
+}
+
+func TestConvertBignum1(t *testing.T) {
+	bi, _ := new(big.Int).SetString("12345", 10)
+	cv, err := ConvertType(reflect.TypeOf(int64(0)), reflect.ValueOf(bi))
+	if err != nil || cv.Int() != 12345 {
+		t.Errorf("wrong bignum convert: %v -> %v / %v", bi, cv, err)
+	}
+	bi, _ = new(big.Int).SetString("123456789012345678901234567890", 10)
+	_, err = ConvertType(reflect.TypeOf(int64(0)), reflect.ValueOf(bi))
+	if err == nil {
+		t.Errorf("bignum overflow should be reported: %v", bi)
+	}
+	cv, err = ConvertType(reflect.TypeOf(bi), reflect.ValueOf(42))
+	if err != nil || cv.Interface().(*big.Int).Int64() != 42 {
+		t.Errorf("wrong int -> bignum convert: %v / %v", cv, err)
+	}
+}
+
+func TestRadixNumbers1(t *testing.T) {
+	data := map[string]srcdata{
+		"hex":         srcdata{"#xff", 255},
+		"radix list":  srcdata{"(#x10 #o10 #b10 10)", []int{16, 8, 2, 10}},
+		"radix float": srcdata{"(#x10 0.5)", []float64{16, 0.5}},
+	}
+	for k, v := range data {
+		testDecodeObject(t, k, v.src, v.exp)
+	}
+	res, _ := Decode1("#x1ffffffffffffffffffff")
+	if bi, ok := res.(*big.Int); !ok || bi.Text(16) != "1ffffffffffffffffffff" {
+		t.Errorf("wrong radix bignum: %v", res)
+	}
+}
+
+func TestChars1(t *testing.T) {
+	data := map[string]srcdata{
+		"char":       srcdata{"?a", 97},
+		"ctrl char":  srcdata{`?\C-x`, 24},
+		"char list":  srcdata{`(?a ?b ?\n)`, []int{97, 98, 10}},
+		"char mixed": srcdata{`(?a 1)`, []int{97, 1}},
+		"key events": srcdata{`[?\C-x ?\M-f]`, []int{24, ps.CharMeta | 'f'}},
+	}
+	for k, v := range data {
+		testDecodeObject(t, k, v.src, v.exp)
+	}
+	res, _ := Decode1With(`(?a ?b)`, &ps.ValueOptions{CharAsRune: true})
+	compareObjectString(t, "rune list", res, []interface{}{'a', 'b'})
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	strs := []string{
+		"", "plain", "a\nb\tc\rd", `quote " and backslash \`, "ctrl \x00\x01\x1f\x7f",
+		"invalid \xff\xfe utf-8", "\xe9", "emoji \U0001F600 and \U0010FFFD", "replacement �",
+		"octal-like \x01" + "234",
+	}
+	check := func(s string) bool {
+		src, err := Encode(s)
+		if err != nil {
+			t.Errorf("encode error [%q]: %v", s, err)
+			return false
+		}
+		v, err := Decode1(string(src))
+		if err != nil {
+			t.Errorf("decode error [%s]: %v", src, err)
+			return false
+		}
+		if v != s {
+			t.Errorf("not round-tripped [%q]: %s -> %q", s, src, v)
+			return false
+		}
+		return true
+	}
+	for _, s := range strs {
+		check(s)
+	}
+	if err := quick.Check(check, nil); err != nil {
+		t.Error(err)
+	}
+	if err := quick.Check(func(b []byte) bool { return check(string(b)) }, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSymbols1(t *testing.T) {
+	o := &ps.ValueOptions{PreserveSymbols: true}
+	res, _ := Decode1With(`(foo "foo" :key t nil)`, o)
+	compareObjectString(t, "symbols", res, []interface{}{Symbol("foo"), "foo", Keyword("key"), true, nil})
+	res, _ = Decode1(`(foo "foo" :key)`)
+	compareObjectString(t, "symbols as strings", res, []interface{}{"foo", "foo", ":key"})
+
+	src := `(:status :success mode emacs-lisp-mode)`
+	res, _ = Decode1With(src, o)
+	enc, err := Encode(res)
+	if err != nil || string(enc) != src {
+		t.Errorf("not round-tripped: %s -> %s (%v)", src, enc, err)
+	}
+}
+
+func TestCons1(t *testing.T) {
+	o := &ps.ValueOptions{PreserveCons: true}
+	src := `((a . 1) (b 2 3) (c . "x"))`
+	res, _ := Decode1With(src, o)
+	compareObjectString(t, "alist", res, []interface{}{Cons{Car: "a", Cdr: 1}, []interface{}{"b", 2, 3}, Cons{Car: "c", Cdr: "x"}})
+	enc, err := Encode(res)
+	if err != nil || string(enc) != `(("a" . 1) ("b" 2 3) ("c" . "x"))` {
+		t.Errorf("wrong encoding: %s (%v)", enc, err)
+	}
+
+	m, err := AlistToMap(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]interface{}{"a": 1, "b": []interface{}{2, 3}, "c": "x"}; !reflect.DeepEqual(m, exp) {
+		t.Errorf("alist map: %#v", m)
+	}
+
+	res, _ = Decode1With(`((:a . 1) (b 2 3) (c . "x") (a . 2))`, &ps.ValueOptions{PreserveSymbols: true})
+	m, err = AlistToMap(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]interface{}{":a": 1, "a": 2, "b": []interface{}{2, 3}, "c": "x"}; !reflect.DeepEqual(m, exp) {
+		t.Errorf("alist map without cons: %#v", m)
+	}
+
+	if _, err := AlistToMap([]interface{}{1, 2}); err == nil {
+		t.Error("Error should be returned for a non-alist.")
+	}
+}
+
+func TestHashTable1(t *testing.T) {
+	srcs := []string{
+		`#s(hash-table test equal data ("a" 1 "b" (1 2)))`,
+		`#s(hash-table test equal data ("z" 1 (1 2) "v" "a" 2))`,
+		`#s(cl-struct-point 1 2.5 #&4"\014")`,
+	}
+	for _, src := range srcs {
+		res, err := Decode1(src)
+		if err != nil {
+			t.Errorf("decode error [%s]: %v", src, err)
+			continue
+		}
+		enc, err := Encode(res)
+		if err != nil || string(enc) != src {
+			t.Errorf("not round-tripped: %s -> %s (%v)", src, enc, err)
+		}
+	}
+	bv, _ := Decode1(`#&4"\f"`)
+	v, err := ConvertType(reflect.TypeOf([]bool{}), reflect.ValueOf(bv))
+	if err != nil || !reflect.DeepEqual(v.Interface(), []bool{false, false, true, true}) {
+		t.Errorf("could not convert bool-vector: %v (%v)", v, err)
+	}
+}
+
+func TestLabels1(t *testing.T) {
+	res, err := Decode1(`(#1=(1 2) #1#)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(res, []interface{}{[]int{1, 2}, []int{1, 2}}) {
+		t.Errorf("shared: %#v", res)
+	}
+	if v := res.([]interface{}); &v[0].([]int)[0] != &v[1].([]int)[0] {
+		t.Error("reference does not share the value of the label")
+	}
+	if _, err := Decode(`#1=(a . #1#)`); err == nil {
+		t.Error("Error should be returned for a cyclic structure.")
+	}
+	if _, err := Decode1(`#1=(a . #1#)`); err == nil {
+		t.Error("Error should be returned for a cyclic structure.")
+	}
+}
+
+func TestDecodeBytes1(t *testing.T) {
+	buf := []byte(`(return 1 ("file contents" sym))`)
+	v, err := Decode1Bytes(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	vs, err := DecodeBytes(buf)
+	if err != nil || len(vs) != 1 {
+		t.Fatalf("DecodeBytes: %v %v", vs, err)
+	}
+	copy(buf, bytes.Repeat([]byte("X"), len(buf)))
+	expected := []interface{}{"return", 1, []interface{}{"file contents", "sym"}}
+	if !reflect.DeepEqual(v, expected) || !reflect.DeepEqual(vs[0], expected) {
+		t.Errorf("Broken by the buffer reuse: %v %v", v, vs[0])
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"bufio"
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
-	"os"
 	"os/exec"
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/kiwanami/go-elrpc/parser"
 )
 
 /// log
@@ -25,10 +26,56 @@ const (
 
 var defaultLogLevel LogLevel = LogLevelInfo
 
+// SetDefaultLogLevel sets the log level of the following connections.
 func SetDefaultLogLevel(l LogLevel) {
 	defaultLogLevel = l
 }
 
+func (l LogLevel) slogLevel() slog.Level {
+	return debugLevel(l == LogLevelDebug)
+}
+
+func debugLevel(d bool) slog.Level {
+	if d {
+		return slog.LevelDebug
+	}
+	return slog.LevelInfo
+}
+
+var defaultLogger *slog.Logger
+
+// SetDefaultLogger sets the logger of the following connections. Nil
+// means slog.Default().
+func SetDefaultLogger(l *slog.Logger) {
+	defaultLogger = l
+}
+
+// newLogger makes the logger filtered by the level, on the handler of l.
+func newLogger(l *slog.Logger, level *slog.LevelVar) *slog.Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slog.New(&levelHandler{Handler: l.Handler(), level: level})
+}
+
+// levelHandler drops the records under the level, before the handler.
+type levelHandler struct {
+	slog.Handler
+	level *slog.LevelVar
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() && h.Handler.Enabled(ctx, l)
+}
+
+func (h *levelHandler) WithAttrs(as []slog.Attr) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithAttrs(as), level: h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
 /// utility
 
 func Array(args ...interface{}) []interface{} {
@@ -49,6 +96,26 @@ type Service interface {
 	WaitingSessionNum() int
 }
 
+// Configurable is the optional interface of the services with the
+// settings of the connections. *RPCServer and *ServerService implement
+// it, and so do the services of StartClient and StartProcess:
+//
+//	if c, ok := svc.(elrpc.Configurable); ok {
+//		c.SetCallTimeout(10 * time.Second)
+//	}
+type Configurable interface {
+	SetLogger(l *slog.Logger)
+	SetLogLevel(l slog.Level)
+	SetDecodeOptions(o *parser.ValueOptions)
+	SetSendQueueSize(n int)
+	SetWriteTimeout(d time.Duration)
+	SetUIDEventHandler(f func(UIDEvent))
+	SetConcurrencyLimit(l ConcurrencyLimit)
+	SetCallTimeout(d time.Duration)
+	SetInterceptors(is ...Interceptor)
+	SetClientInterceptors(is ...Interceptor)
+}
+
 /// Server
 
 func queryFreePort() (int, error) {
@@ -74,7 +141,6 @@ func StartServer(methods []*Method) (*ServerService, error) {
 }
 
 func StartServerWithPort(methods []*Method, port int) (*ServerService, error) {
-	logger := log.New(os.Stderr, fmt.Sprintf("%s ", "SS"), log.Ldate|log.Ltime)
 	if port == 0 {
 		nport, err := queryFreePort()
 		if err != nil {
@@ -92,32 +158,40 @@ func StartServerWithPort(methods []*Method, port int) (*ServerService, error) {
 	if methods != nil {
 		methods = []*Method{}
 	}
-	debugMode := false
-	if defaultLogLevel == LogLevelDebug {
-		debugMode = true
-	}
 	ss := &ServerService{
-		count:       0,
-		logger:      logger,
-		debugMode:   debugMode,
-		serverState: serverStateOpened,
-		listener:    ln,
-		services:    []Service{},
-		methods:     methods,
+		count:        0,
+		logLevel:     new(slog.LevelVar),
+		writeTimeout: DefaultWriteTimeout,
+		serverState:  serverStateOpened,
+		listener:     ln,
+		services:     []*RPCServer{},
+		methods:      methods,
 	}
+	ss.SetLogLevel(defaultLogLevel.slogLevel())
+	ss.SetLogger(defaultLogger)
 
 	return ss, nil
 }
 
 type ServerService struct {
-	count       int        // counter for accepted servers
-	countMu     sync.Mutex // protect for count
-	debugMode   bool
-	logger      *log.Logger
-	serverState serverState
-	listener    net.Listener
-	services    []Service
-	methods     []*Method
+	count        int        // counter for accepted servers
+	countMu      sync.Mutex // protect for count
+	mu           sync.Mutex // guards the settings, services and methods
+	decodeOpts   *parser.ValueOptions
+	queueSize    int
+	writeTimeout time.Duration
+	uidHandler   func(UIDEvent)
+	limiter      *limiter // shared by the connections
+	callTimeout  time.Duration
+	interceptors []Interceptor
+	clientIcs    []Interceptor
+	baseLogger   *slog.Logger // for the connections
+	logger       *slog.Logger
+	logLevel     *slog.LevelVar
+	serverState  serverState
+	listener     net.Listener
+	services     []*RPCServer
+	methods      []*Method
 }
 
 func (ss *ServerService) incServerCount() int {
@@ -127,17 +201,93 @@ func (ss *ServerService) incServerCount() int {
 	return ss.count
 }
 
-func (ss *ServerService) SetDebug(a bool) {
-	ss.debugMode = a
+// update changes the setting with set, and applies it to the current
+// connections with apply. The connections accepted during it get the new
+// setting too.
+func (ss *ServerService) update(set func(), apply func(s *RPCServer)) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	set()
 	for _, s := range ss.services {
-		s.SetDebug(a)
+		apply(s)
 	}
 }
 
-func (ss *ServerService) debugf(format string, args ...interface{}) {
-	if ss.debugMode {
-		ss.logger.Printf(format, args...)
-	}
+func (ss *ServerService) log() *slog.Logger {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.logger
+}
+
+// SetDebug switches the log level between debug and info for the
+// current and the following connections.
+func (ss *ServerService) SetDebug(a bool) {
+	ss.SetLogLevel(debugLevel(a))
+}
+
+// SetLogger sets the logger for the current and the following
+// connections. Nil means the default logger.
+func (ss *ServerService) SetLogger(l *slog.Logger) {
+	ss.update(func() {
+		ss.baseLogger = l
+		ss.logger = newLogger(l, ss.logLevel).With("conn", "SS")
+	}, func(s *RPCServer) { s.SetLogger(l) })
+}
+
+// SetLogLevel sets the log level for the current and the following
+// connections.
+func (ss *ServerService) SetLogLevel(l slog.Level) {
+	ss.update(func() { ss.logLevel.Set(l) }, func(s *RPCServer) { s.SetLogLevel(l) })
+}
+
+// SetDecodeOptions sets the options to decode incoming messages for the
+// current and the following connections.
+func (ss *ServerService) SetDecodeOptions(o *parser.ValueOptions) {
+	ss.update(func() { ss.decodeOpts = o }, func(s *RPCServer) { s.SetDecodeOptions(o) })
+}
+
+// SetSendQueueSize sets the size of the sending queue for the current and
+// the following connections.
+func (ss *ServerService) SetSendQueueSize(n int) {
+	ss.update(func() { ss.queueSize = n }, func(s *RPCServer) { s.SetSendQueueSize(n) })
+}
+
+// SetWriteTimeout sets the write timeout for the current and the
+// following connections.
+func (ss *ServerService) SetWriteTimeout(d time.Duration) {
+	ss.update(func() { ss.writeTimeout = d }, func(s *RPCServer) { s.SetWriteTimeout(d) })
+}
+
+// SetUIDEventHandler sets the handler of the uid events for the current
+// and the following connections.
+func (ss *ServerService) SetUIDEventHandler(f func(UIDEvent)) {
+	ss.update(func() { ss.uidHandler = f }, func(s *RPCServer) { s.SetUIDEventHandler(f) })
+}
+
+// SetConcurrencyLimit sets the limit of the calls running at once on
+// all the current and the following connections together. The limits of
+// the methods are shared by the connections too.
+func (ss *ServerService) SetConcurrencyLimit(l ConcurrencyLimit) {
+	lm := newLimiter(l)
+	ss.update(func() { ss.limiter = lm }, func(s *RPCServer) { s.setLimiter(lm) })
+}
+
+// SetCallTimeout sets the default timeout of the calls to the peers for
+// the current and the following connections.
+func (ss *ServerService) SetCallTimeout(d time.Duration) {
+	ss.update(func() { ss.callTimeout = d }, func(s *RPCServer) { s.SetCallTimeout(d) })
+}
+
+// SetInterceptors sets the interceptors of the calls from the peers for
+// the current and the following connections.
+func (ss *ServerService) SetInterceptors(is ...Interceptor) {
+	ss.update(func() { ss.interceptors = is }, func(s *RPCServer) { s.SetInterceptors(is...) })
+}
+
+// SetClientInterceptors sets the interceptors of the calls to the peers
+// for the current and the following connections.
+func (ss *ServerService) SetClientInterceptors(is ...Interceptor) {
+	ss.update(func() { ss.clientIcs = is }, func(s *RPCServer) { s.SetClientInterceptors(is...) })
 }
 
 func (ss *ServerService) Close() {
@@ -150,21 +300,39 @@ func (ss *ServerService) Close() {
 }
 
 func (ss *ServerService) RegisterMethod(m *Method) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	ss.methods = append(ss.methods, m)
 }
 
 func (ss *ServerService) Accept() (*RPCServer, error) {
-	ss.debugf("waiting for client connection...")
+	ss.log().Debug("waiting for client connection")
 	conn, err := ss.listener.Accept()
 	if err != nil {
 		return nil, err
 	}
-	ss.debugf("incoming connection.")
-	s := makeRPCServer(
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.logger.Debug("incoming connection", "remote", conn.RemoteAddr())
+	// apply the settings, such as the interceptors, before the workers
+	// read the first message
+	s := newRPCServer(
 		fmt.Sprintf("SS%d", ss.incServerCount()),
 		conn, ss.methods)
-	s.SetDebug(ss.debugMode)
-	ss.debugf("make a rpc server.")
+	s.SetLogLevel(ss.logLevel.Level())
+	s.SetLogger(ss.baseLogger)
+	s.SetDecodeOptions(ss.decodeOpts)
+	if ss.queueSize > 0 {
+		s.SetSendQueueSize(ss.queueSize)
+	}
+	s.SetWriteTimeout(ss.writeTimeout)
+	s.SetUIDEventHandler(ss.uidHandler)
+	s.setLimiter(ss.limiter)
+	s.SetCallTimeout(ss.callTimeout)
+	s.SetInterceptors(ss.interceptors...)
+	s.SetClientInterceptors(ss.clientIcs...)
+	s.start()
+	ss.logger.Debug("make a rpc server", "name", s.name)
 	ss.services = append(ss.services, s)
 	return s, nil
 }
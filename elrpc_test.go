@@ -3,8 +3,10 @@ package elrpc
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"os/exec"
 	"reflect"
 	"strconv"
@@ -262,8 +264,8 @@ func TestFlatmap1(t *testing.T) {
 		if err != nil {
 			return err
 		}
-		exp := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
-		reti := ret.([]int)
+		exp := []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+		reti := ret.([]float64)
 		if !reflect.DeepEqual(reti, exp) {
 			t.Errorf("expected[%v] but returned [%v]", exp, reti)
 		}
@@ -469,3 +471,108 @@ func TestEpcCancel(t *testing.T) {
 		t.Error(err.Error())
 	}
 }
+
+func TestServerServiceSettings(t *testing.T) {
+	ss, err := StartServer(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ss.Close()
+	ss.RegisterMethod(MakeMethod("secret", func() int { return 1 }, "", ""))
+	ss.SetInterceptors(func(ctx context.Context, info *CallInfo, next Handler) (interface{}, error) {
+		return nil, fmt.Errorf("forbidden: %s", info.Method)
+	})
+	accepted := make(chan *RPCServer, 1)
+	go func() {
+		s, err := ss.Accept()
+		if err != nil {
+			t.Error(err.Error())
+		}
+		accepted <- s
+	}()
+
+	cl, err := StartClient(ss.listener.Addr().(*net.TCPAddr).Port, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cl.Stop()
+	// change the settings while the connection is being accepted
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			ss.SetCallTimeout(time.Duration(i) * time.Second)
+			ss.SetLogLevel(debugLevel(i%2 == 0))
+		}
+	}()
+	if _, err := cl.Call("secret"); err == nil || !strings.Contains(err.Error(), "forbidden: secret") {
+		t.Errorf("The interceptor was not applied to the first call: %v", err)
+	}
+	wg.Wait()
+	if s := <-accepted; s != nil {
+		if d := s.cfg().callTimeout; d != 99*time.Second {
+			t.Errorf("Unexpected call timeout: %v", d)
+		}
+		s.Stop()
+	}
+}
+
+func TestServerServiceConcurrencyLimit(t *testing.T) {
+	ss, err := StartServer(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer ss.Close()
+	gate := make(chan struct{})
+	ss.RegisterMethod(MakeMethod("block", func() int { <-gate; return 1 }, "", ""))
+	ss.SetConcurrencyLimit(ConcurrencyLimit{Max: 1, Reject: true})
+	accepted := make(chan *RPCServer, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			s, err := ss.Accept()
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+			accepted <- s
+		}
+	}()
+	port := ss.listener.Addr().(*net.TCPAddr).Port
+	cl1, err := StartClient(port, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cl1.Stop()
+	cl2, err := StartClient(port, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer cl2.Stop()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := cl1.Call("block")
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	// the limit is shared by the connections
+	if _, err := cl2.Call("block"); !errors.Is(err, ErrTooManyCalls) {
+		t.Errorf("The call over the limit was not rejected: %v", err)
+	}
+	close(gate)
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		(<-accepted).Stop()
+	}
+}
+
+func TestConfigurable(t *testing.T) {
+	for _, v := range []interface{}{&RPCServer{}, &ServerService{}, &clientService{}} {
+		if _, ok := v.(Configurable); !ok {
+			t.Errorf("%T is not Configurable", v)
+		}
+	}
+}
@@ -3,10 +3,12 @@ package elrpc
 import (
 	"bytes"
 	"math"
+	"math/big"
 	"reflect"
 	"runtime"
 	"sort"
 	"strconv"
+	"sync"
 
 	"github.com/kiwanami/go-elrpc/parser"
 )
@@ -39,10 +41,35 @@ func (e *UnsupportedValueError) Error() string {
 type encodeState struct {
 	bytes.Buffer
 	scratch [64]byte
+
+	// ptrSeen holds the pointers, maps and slices being encoded, to detect
+	// cyclic values.
+	ptrSeen map[interface{}]struct{}
+
+	// labels holds the shared pointers, maps and slices for print-circle,
+	// with the label numbers once they are written.
+	labels    map[ptrKey]int
+	lastLabel int
+}
+
+// EncodeOptions controls the encoding of Go objects.
+// The nil options mean the default behavior.
+type EncodeOptions struct {
+	PrintCircle bool // write the shared pointers, maps and slices with the labels, #1= and #1#
 }
 
 func Encode(obj interface{}) ([]byte, error) {
+	return EncodeWith(obj, nil)
+}
+
+// EncodeWith encodes a Go object into S-expression with the options.
+// With PrintCircle, the cyclic values are also written with the labels
+// instead of an error.
+func EncodeWith(obj interface{}, o *EncodeOptions) ([]byte, error) {
 	s := &encodeState{}
+	if o != nil && o.PrintCircle {
+		s.labels = sharedValues(reflect.ValueOf(obj))
+	}
 	err := s.encode(obj)
 	if err != nil {
 		return nil, err
@@ -54,6 +81,110 @@ func (e *encodeState) error(err error) {
 	panic(err)
 }
 
+// ptrKey identifies a pointer, map or slice being encoded.
+type ptrKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enter marks v as being encoded. It reports an UnsupportedValueError
+// when v is already on the way from the root, that is, v is cyclic.
+func (e *encodeState) enter(v reflect.Value) ptrKey {
+	k := keyOf(v)
+	if _, ok := e.ptrSeen[k]; ok {
+		e.error(&UnsupportedValueError{v, "encountered a cycle via " + v.Type().String()})
+	}
+	if e.ptrSeen == nil {
+		e.ptrSeen = make(map[interface{}]struct{})
+	}
+	e.ptrSeen[k] = struct{}{}
+	return k
+}
+
+func (e *encodeState) leave(k ptrKey) {
+	delete(e.ptrSeen, k)
+}
+
+func keyOf(v reflect.Value) ptrKey {
+	k := ptrKey{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		k.len = v.Len()
+	}
+	return k
+}
+
+// label writes the label of a shared value for print-circle. It reports
+// true when v is already written and only the reference is written.
+func (e *encodeState) label(v reflect.Value) bool {
+	k := keyOf(v)
+	n, ok := e.labels[k]
+	if !ok {
+		return false
+	}
+	if n > 0 {
+		e.WriteString("#" + strconv.Itoa(n) + "#")
+		return true
+	}
+	e.lastLabel++
+	e.labels[k] = e.lastLabel
+	e.WriteString("#" + strconv.Itoa(e.lastLabel) + "=")
+	return false
+}
+
+// sharedValues returns the pointers, maps and slices referred more than
+// once in v.
+func sharedValues(v reflect.Value) map[ptrKey]int {
+	counts := make(map[ptrKey]int)
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice:
+			if v.IsNil() || (v.Kind() == reflect.Slice && v.Len() == 0) {
+				return
+			}
+			k := keyOf(v)
+			counts[k]++
+			if counts[k] > 1 {
+				return
+			}
+			switch v.Kind() {
+			case reflect.Ptr:
+				walk(v.Elem())
+			case reflect.Map:
+				for _, mk := range v.MapKeys() {
+					walk(mk)
+					walk(v.MapIndex(mk))
+				}
+			default:
+				for i := 0; i < v.Len(); i++ {
+					walk(v.Index(i))
+				}
+			}
+		case reflect.Interface:
+			if !v.IsNil() {
+				walk(v.Elem())
+			}
+		case reflect.Array:
+			for i := 0; i < v.Len(); i++ {
+				walk(v.Index(i))
+			}
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				walk(v.Field(i))
+			}
+		}
+	}
+	walk(v)
+	ret := make(map[ptrKey]int)
+	for k, c := range counts {
+		if c > 1 {
+			ret[k] = 0 // not written yet
+		}
+	}
+	return ret
+}
+
 func (s *encodeState) encode(v interface{}) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -83,7 +214,63 @@ func valueEncoder(v reflect.Value) encoderFunc {
 	return typeEncoder(v.Type())
 }
 
+var encoderCache sync.Map // map[reflect.Type]encoderFunc
+
 func typeEncoder(t reflect.Type) encoderFunc {
+	if fi, ok := encoderCache.Load(t); ok {
+		return fi.(encoderFunc)
+	}
+
+	// To deal with recursive types, populate the map with an
+	// indirect func before we build it. This type waits on the
+	// real func (f) to be ready and then calls it. This indirect
+	// func is only used for recursive types.
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	fi, loaded := encoderCache.LoadOrStore(t, encoderFunc(func(e *encodeState, v reflect.Value, quoted bool) {
+		wg.Wait()
+		f(e, v, quoted)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
+	}
+
+	// Compute the real encoder and replace the indirect func with it.
+	f = newTypeEncoder(t)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
+
+var (
+	bigIntType   = reflect.TypeOf(big.Int{})
+	bigFloatType = reflect.TypeOf(big.Float{})
+)
+
+func newTypeEncoder(t reflect.Type) encoderFunc {
+	switch t {
+	case bigIntType:
+		return bigIntEncoder
+	case bigFloatType:
+		return bigFloatEncoder
+	case symbolType:
+		return symbolEncoder
+	case keywordType:
+		return keywordEncoder
+	case consType:
+		return consEncoder
+	case hashTableType:
+		return hashTableEncoder
+	case recordType:
+		return recordEncoder
+	case boolVectorType:
+		return boolVectorEncoder
+	case propertizedStringType:
+		return propertizedStringEncoder
+	}
 	switch t.Kind() {
 	case reflect.Bool:
 		return boolEncoder
@@ -154,14 +341,40 @@ func uintEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	}
 }
 
-type floatEncoder int // number of bits
+func bigIntEncoder(e *encodeState, v reflect.Value, quoted bool) {
+	var bi *big.Int
+	if v.CanAddr() {
+		bi = v.Addr().Interface().(*big.Int)
+	} else {
+		vi := v.Interface().(big.Int)
+		bi = &vi
+	}
+	if quoted {
+		e.WriteByte('"')
+	}
+	e.Write(bi.Append(e.scratch[:0], 10))
+	if quoted {
+		e.WriteByte('"')
+	}
+}
 
-func (bits floatEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
-	f := v.Float()
-	if math.IsInf(f, 0) || math.IsNaN(f) {
-		e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+func bigFloatEncoder(e *encodeState, v reflect.Value, quoted bool) {
+	var bf *big.Float
+	if v.CanAddr() {
+		bf = v.Addr().Interface().(*big.Float)
+	} else {
+		vf := v.Interface().(big.Float)
+		bf = &vf
+	}
+	var b []byte
+	if bf.IsInf() {
+		b = append(e.scratch[:0], parser.FloatLiteral(math.Inf(bf.Sign()), 64)...)
+	} else {
+		b = bf.Append(e.scratch[:0], 'g', -1)
+		if !bytes.ContainsAny(b, ".e") {
+			b = append(b, ".0"...) // "2" is read as an integer
+		}
 	}
-	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, int(bits))
 	if quoted {
 		e.WriteByte('"')
 	}
@@ -171,6 +384,18 @@ func (bits floatEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
 	}
 }
 
+type floatEncoder int // number of bits
+
+func (bits floatEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
+	if quoted {
+		e.WriteByte('"')
+	}
+	e.WriteString(parser.FloatLiteral(v.Float(), int(bits)))
+	if quoted {
+		e.WriteByte('"')
+	}
+}
+
 var (
 	float32Encoder = (floatEncoder(32)).encode
 	float64Encoder = (floatEncoder(64)).encode
@@ -180,6 +405,158 @@ type Number string
 
 var numberType = reflect.TypeOf(Number(""))
 
+// Symbol is encoded as an Emacs symbol, such as major-mode.
+type Symbol = parser.Symbol
+
+// Keyword is encoded as an Emacs keyword, such as :success for Keyword("success").
+type Keyword = parser.Keyword
+
+var (
+	symbolType  = reflect.TypeOf(Symbol(""))
+	keywordType = reflect.TypeOf(Keyword(""))
+)
+
+func symbolEncoder(e *encodeState, v reflect.Value, _ bool) {
+	e.symbol(v.String())
+}
+
+func keywordEncoder(e *encodeState, v reflect.Value, _ bool) {
+	e.symbol(":" + v.String())
+}
+
+// Cons is encoded as an Emacs dotted pair, such as (1 . 2) for Cons{1, 2}.
+type Cons = parser.Cons
+
+var consType = reflect.TypeOf(Cons{})
+
+func consEncoder(e *encodeState, v reflect.Value, _ bool) {
+	e.WriteByte('(')
+	consElemEncoder(e, v.Field(0))
+	e.WriteString(" . ")
+	consElemEncoder(e, v.Field(1))
+	e.WriteByte(')')
+}
+
+func consElemEncoder(e *encodeState, v reflect.Value) {
+	if v.IsNil() {
+		e.WriteString("nil") // (a . nil) is read as (a)
+		return
+	}
+	e.reflectValue(v.Elem())
+}
+
+// HashTable is encoded as #s(hash-table test equal data (k1 v1 k2 v2)).
+type HashTable = parser.HashTable
+
+// Record is encoded as #s(type slot1 slot2).
+type Record = parser.Record
+
+// BoolVector is encoded as #&N"...", while []bool is encoded as a list.
+type BoolVector = parser.BoolVector
+
+var (
+	hashTableType  = reflect.TypeOf(HashTable{})
+	recordType     = reflect.TypeOf(Record{})
+	boolVectorType = reflect.TypeOf(BoolVector{})
+)
+
+func hashTableEncoder(e *encodeState, v reflect.Value, _ bool) {
+	ht := v.Interface().(HashTable)
+	e.WriteString("#s(hash-table")
+	if ht.Test != "" {
+		e.WriteString(" test ")
+		e.symbol(ht.Test)
+	}
+	e.WriteString(" data (")
+	if ht.Alist != nil {
+		for i, c := range ht.Alist {
+			if i > 0 {
+				e.WriteByte(' ')
+			}
+			e.reflectValue(reflect.ValueOf(c.Car))
+			e.WriteByte(' ')
+			e.reflectValue(reflect.ValueOf(c.Cdr))
+		}
+		e.WriteString("))")
+		return
+	}
+	// sort the keys by the encoded ones for the stable output
+	keys := make([]interface{}, 0, len(ht.Data))
+	sortKeys := make(map[interface{}]string, len(ht.Data))
+	for k := range ht.Data {
+		sk, err := Encode(k)
+		if err != nil {
+			e.error(err)
+		}
+		keys = append(keys, k)
+		sortKeys[k] = string(sk)
+	}
+	sort.Slice(keys, func(i, j int) bool { return sortKeys[keys[i]] < sortKeys[keys[j]] })
+	for i, k := range keys {
+		if i > 0 {
+			e.WriteByte(' ')
+		}
+		e.reflectValue(reflect.ValueOf(k))
+		e.WriteByte(' ')
+		e.reflectValue(reflect.ValueOf(ht.Data[k]))
+	}
+	e.WriteString("))")
+}
+
+func recordEncoder(e *encodeState, v reflect.Value, _ bool) {
+	r := v.Interface().(Record)
+	e.WriteString("#s(")
+	e.symbol(r.Type)
+	for _, s := range r.Slots {
+		e.WriteByte(' ')
+		e.reflectValue(reflect.ValueOf(s))
+	}
+	e.WriteByte(')')
+}
+
+func boolVectorEncoder(e *encodeState, v reflect.Value, _ bool) {
+	e.WriteString(parser.BoolVectorLiteral(v.Interface().(BoolVector)))
+}
+
+// PropertizedString is encoded as #("text" 0 4 (face bold)). The property
+// names are written as symbols, and the values as usual, so that a face
+// name should be a Symbol.
+type PropertizedString = parser.PropertizedString
+
+// TextInterval is a range of characters with the text properties.
+type TextInterval = parser.TextInterval
+
+var propertizedStringType = reflect.TypeOf(PropertizedString{})
+
+func propertizedStringEncoder(e *encodeState, v reflect.Value, _ bool) {
+	ps := v.Interface().(PropertizedString)
+	e.WriteString("#(")
+	e.string(ps.Text)
+	for _, ti := range ps.Intervals {
+		e.WriteString(" " + strconv.Itoa(ti.Start) + " " + strconv.Itoa(ti.End) + " ")
+		if len(ti.Props) == 0 {
+			e.WriteString("nil")
+			continue
+		}
+		keys := make([]string, 0, len(ti.Props))
+		for k := range ti.Props {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		e.WriteByte('(')
+		for i, k := range keys {
+			if i > 0 {
+				e.WriteByte(' ')
+			}
+			e.symbol(k)
+			e.WriteByte(' ')
+			e.reflectValue(reflect.ValueOf(ti.Props[k]))
+		}
+		e.WriteByte(')')
+	}
+	e.WriteByte(')')
+}
+
 func stringEncoder(e *encodeState, v reflect.Value, quoted bool) {
 	if v.Type() == numberType {
 		numStr := v.String()
@@ -213,6 +590,7 @@ func unsupportedTypeEncoder(e *encodeState, v reflect.Value, quoted bool) {
 }
 
 type mapEncoder struct {
+	keyEnc  encoderFunc
 	elemEnc encoderFunc
 }
 
@@ -221,27 +599,40 @@ func (me *mapEncoder) encode(e *encodeState, v reflect.Value, _ bool) {
 		e.WriteString("null")
 		return
 	}
+	if e.label(v) {
+		return
+	}
+	k := e.enter(v)
 	e.WriteByte('(')
 	var sv stringValues = v.MapKeys()
 	sort.Sort(sv)
-	for i, k := range sv {
+	for i, key := range sv {
 		if i > 0 {
 			e.WriteString(" ")
 		}
 		e.WriteByte('(')
-		e.string(k.String())
+		me.keyEnc(e, key, false)
 		e.WriteString(" . ")
-		me.elemEnc(e, v.MapIndex(k), false)
+		me.elemEnc(e, v.MapIndex(key), false)
 		e.WriteByte(')')
 	}
 	e.WriteByte(')')
+	e.leave(k)
+}
+
+func stringKeyEncoder(e *encodeState, v reflect.Value, _ bool) {
+	e.string(v.String())
 }
 
 func newMapEncoder(t reflect.Type) encoderFunc {
 	if t.Key().Kind() != reflect.String {
 		return unsupportedTypeEncoder
 	}
-	me := &mapEncoder{typeEncoder(t.Elem())}
+	keyEnc := stringKeyEncoder
+	if t.Key() == symbolType || t.Key() == keywordType {
+		keyEnc = typeEncoder(t.Key()) // alist with symbol keys
+	}
+	me := &mapEncoder{keyEnc, typeEncoder(t.Elem())}
 	return me.encode
 }
 
@@ -255,7 +646,16 @@ func (se *sliceEncoder) encode(e *encodeState, v reflect.Value, _ bool) {
 		e.WriteString("nil")
 		return
 	}
+	if v.Len() == 0 {
+		se.arrayEnc(e, v, false)
+		return
+	}
+	if e.label(v) {
+		return
+	}
+	k := e.enter(v)
 	se.arrayEnc(e, v, false)
+	e.leave(k)
 }
 
 func newSliceEncoder(t reflect.Type) encoderFunc {
@@ -293,7 +693,12 @@ func (pe *ptrEncoder) encode(e *encodeState, v reflect.Value, quoted bool) {
 		e.WriteString("nil")
 		return
 	}
+	if e.label(v) {
+		return
+	}
+	k := e.enter(v)
 	pe.elemEnc(e, v.Elem(), quoted)
+	e.leave(k)
 }
 
 func newPtrEncoder(t reflect.Type) encoderFunc {
@@ -304,9 +709,12 @@ func newPtrEncoder(t reflect.Type) encoderFunc {
 var hex = "0123456789abcdef"
 
 func (e *encodeState) symbol(s string) (int, error) {
-	len0 := e.Len()
-	e.WriteString(parser.SymbolLiteral(s))
-	return e.Len() - len0, nil
+	lit, err := parser.SymbolLiteralChecked(s)
+	if err != nil {
+		e.error(&UnsupportedValueError{reflect.ValueOf(s), err.Error()})
+	}
+	e.WriteString(lit)
+	return len(lit), nil
 }
 
 func (e *encodeState) string(s string) (int, error) {
@@ -1,6 +1,10 @@
 package elrpc
 
-import "testing"
+import (
+	"math"
+	"math/big"
+	"testing"
+)
 
 func testCompare(t *testing.T, obj interface{}, expected string) {
 	res, err := Encode(obj)
@@ -100,3 +104,173 @@ func TestUnicode1(t *testing.T) {
 	a3 := "unicode 日本語 japanese"
 	testCompare(t, a3, `"unicode 日本語 japanese"`)
 }
+
+type testNode struct {
+	Name     string
+	Children []*testNode
+}
+
+func TestEncoderRecursiveType(t *testing.T) {
+	tree := &testNode{"root", []*testNode{
+		{"a", nil},
+		{"b", []*testNode{{"c", nil}}},
+	}}
+	testCompare(t, tree, `((Name . "root") (Children . (((Name . "a") (Children . nil)) ((Name . "b") (Children . (((Name . "c") (Children . nil))))))))`)
+}
+
+type testList struct {
+	Value int
+	Next  *testList
+}
+
+func TestEncoderSharedValue(t *testing.T) {
+	shared := &testList{Value: 1}
+	testCompare(t, []*testList{shared, shared}, `(((Value . 1) (Next . nil)) ((Value . 1) (Next . nil)))`)
+}
+
+func testCycleError(t *testing.T, msg string, obj interface{}) {
+	_, err := Encode(obj)
+	if err == nil {
+		t.Errorf("%s: cycle error should be returned", msg)
+		return
+	}
+	if _, ok := err.(*UnsupportedValueError); !ok {
+		t.Errorf("%s: unexpected error: %v", msg, err)
+	}
+}
+
+func TestEncoderCyclicValue(t *testing.T) {
+	l := &testList{Value: 1}
+	l.Next = &testList{Value: 2, Next: l}
+	testCycleError(t, "pointer", l)
+
+	m := map[string]interface{}{}
+	m["self"] = m
+	testCycleError(t, "map", m)
+
+	s := []interface{}{1, nil}
+	s[1] = s
+	testCycleError(t, "slice", s)
+}
+
+func TestEncoderFloat(t *testing.T) {
+	testCompare(t, 2.0, `2.0`)
+	testCompare(t, -0.0, `0.0`)
+	testCompare(t, math.Copysign(0, -1), `-0.0`)
+	testCompare(t, 1e6, `1e+06`)
+	testCompare(t, 1.5e-10, `1.5e-10`)
+	testCompare(t, float32(3), `3.0`)
+	testCompare(t, math.Inf(1), `1.0e+INF`)
+	testCompare(t, math.Inf(-1), `-1.0e+INF`)
+	testCompare(t, math.NaN(), `0.0e+NaN`)
+}
+
+func TestFloatRoundTrip(t *testing.T) {
+	fs := []float64{
+		0, 1, -1, 2.5, 1e6, 1e21, 1e-7, math.MaxFloat64, math.SmallestNonzeroFloat64,
+		math.Pi, math.Copysign(0, -1), math.Inf(1), math.Inf(-1), math.NaN(),
+	}
+	for _, f := range fs {
+		src, err := Encode(f)
+		if err != nil {
+			t.Errorf("encode error [%v]: %v", f, err)
+			continue
+		}
+		v, err := Decode1(string(src))
+		if err != nil {
+			t.Errorf("decode error [%s]: %v", src, err)
+			continue
+		}
+		rf, ok := v.(float64)
+		if !ok {
+			t.Errorf("not decoded as float [%s]: %v", src, v)
+			continue
+		}
+		if math.Float64bits(rf) != math.Float64bits(f) && !(math.IsNaN(f) && math.IsNaN(rf)) {
+			t.Errorf("not round-tripped [%v]: %s -> %v", f, src, rf)
+		}
+	}
+}
+
+func TestEncoderBignum(t *testing.T) {
+	bi, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	testCompare(t, bi, `-123456789012345678901234567890`)
+	testCompare(t, *big.NewInt(7), `7`)
+	testCompare(t, []*big.Int{big.NewInt(1), bi}, `(1 -123456789012345678901234567890)`)
+	testCompare(t, big.NewFloat(2), `2.0`)
+	testCompare(t, big.NewFloat(1.5e300), `1.5e+300`)
+	testCompare(t, new(big.Float).SetInf(true), `-1.0e+INF`)
+}
+
+func TestEncoderSymbol(t *testing.T) {
+	testCompare(t, Symbol("major-mode"), `major-mode`)
+	testCompare(t, Keyword("success"), `:success`)
+	testCompare(t, Symbol("a b"), `a\ b`)
+	testCompare(t, []interface{}{Symbol("quote"), "str"}, `(quote "str")`)
+	testCompare(t, map[Keyword]int{"a": 1}, `((:a . 1))`)
+	testCompare(t, map[Symbol]string{"b": "x"}, `((b . "x"))`)
+	if b, err := Encode(Symbol("a\xffb")); err == nil {
+		t.Errorf("Invalid UTF-8 symbol is encoded: %q", b)
+	}
+}
+
+func TestEncoderCons(t *testing.T) {
+	testCompare(t, Cons{Car: 1, Cdr: 2}, `(1 . 2)`)
+	testCompare(t, Cons{Car: Symbol("a"), Cdr: Cons{Car: "b", Cdr: 1.5}}, `(a . ("b" . 1.5))`)
+	testCompare(t, &Cons{}, `(nil . nil)`)
+	testCompare(t, []Cons{{Car: Keyword("k"), Cdr: 1}}, `((:k . 1))`)
+}
+
+func TestEncoderHashTable(t *testing.T) {
+	testCompare(t, HashTable{Test: "equal", Data: map[interface{}]interface{}{"b": 2, Symbol("a"): []int{1}}},
+		`#s(hash-table test equal data ("b" 2 a (1)))`)
+	testCompare(t, HashTable{}, `#s(hash-table data ())`)
+	testCompare(t, HashTable{Test: "equal", Alist: []Cons{{Car: []int{1, 2}, Cdr: "v"}, {Car: "k", Cdr: 1}}},
+		`#s(hash-table test equal data ((1 2) "v" "k" 1))`)
+	if _, err := Encode(HashTable{Data: map[interface{}]interface{}{make(chan int): 1}}); err == nil {
+		t.Error("unsupported key should be an error")
+	}
+	testCompare(t, Record{Type: "point", Slots: []interface{}{1, "x"}}, `#s(point 1 "x")`)
+	testCompare(t, BoolVector{true, false, true}, `#&3"\005"`)
+	testCompare(t, []bool{true, false}, `(t nil)`)
+}
+
+func TestEncoderPropertizedString(t *testing.T) {
+	testCompare(t, PropertizedString{Text: "if x", Intervals: []TextInterval{
+		{Start: 0, End: 2, Props: map[string]interface{}{"face": Symbol("font-lock-keyword-face"), "help-echo": "keyword"}},
+		{Start: 3, End: 4},
+	}}, `#("if x" 0 2 (face font-lock-keyword-face help-echo "keyword") 3 4 nil)`)
+	testCompare(t, PropertizedString{Text: "plain"}, `#("plain")`)
+}
+
+func testCompareCircle(t *testing.T, obj interface{}, expected string) {
+	res, err := EncodeWith(obj, &EncodeOptions{PrintCircle: true})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(res) != expected {
+		t.Errorf("Not equal exp:[%s] -> result:[%s]", expected, res)
+	}
+}
+
+func TestEncoderPrintCircle(t *testing.T) {
+	type node struct {
+		Name string
+		Next *node
+	}
+	shared := &node{Name: "s"}
+	testCompareCircle(t, []*node{shared, shared, {Name: "x"}},
+		`(#1=((Name . "s") (Next . nil)) #1# ((Name . "x") (Next . nil)))`)
+
+	cyclic := &node{Name: "c"}
+	cyclic.Next = cyclic
+	testCompareCircle(t, cyclic, `#1=((Name . "c") (Next . #1#))`)
+	if _, err := Encode(cyclic); err == nil {
+		t.Error("Error should be returned without print-circle.")
+	}
+
+	lst := []int{1, 2}
+	testCompareCircle(t, map[string][]int{"a": lst, "b": lst}, `(("a" . #1=(1 2)) ("b" . #1#))`)
+	testCompareCircle(t, []int{1, 2}, `(1 2)`)
+}
@@ -0,0 +1,1305 @@
+package interp
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/kiwanami/go-elrpc"
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+func boolValue(b bool) interface{} {
+	if b {
+		return true
+	}
+	return nil
+}
+
+// listArg returns the elements of a proper list or a vector.
+func listArg(v interface{}) ([]interface{}, error) {
+	switch l := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return l, nil
+	case Vector:
+		return l, nil
+	}
+	return nil, signal("wrong-type-argument", parser.Symbol("listp"), v)
+}
+
+func stringArg(v interface{}) (string, error) {
+	switch s := v.(type) {
+	case string:
+		return s, nil
+	case parser.Symbol:
+		return string(s), nil
+	}
+	return "", signal("wrong-type-argument", parser.Symbol("stringp"), v)
+}
+
+func intArg(v interface{}) (int, error) {
+	if i, ok := v.(int); ok {
+		return i, nil
+	}
+	return 0, signal("wrong-type-argument", parser.Symbol("integerp"), v)
+}
+
+func numberArg(v interface{}) (interface{}, error) {
+	switch v.(type) {
+	case int, *big.Int, float64:
+		return v, nil
+	}
+	return nil, signal("wrong-type-argument", parser.Symbol("number-or-marker-p"), v)
+}
+
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, *big.Int:
+		return true
+	}
+	return false
+}
+
+// bigInt returns the integer, int or *big.Int, as *big.Int.
+func bigInt(v interface{}) *big.Int {
+	if i, ok := v.(int); ok {
+		return big.NewInt(int64(i))
+	}
+	return v.(*big.Int)
+}
+
+// normInt returns the bignum as int if it fits, as the reader does.
+func normInt(b *big.Int) interface{} {
+	if b.IsInt64() {
+		if i := b.Int64(); int64(int(i)) == i {
+			return int(i)
+		}
+	}
+	return b
+}
+
+func arity(name string, args []interface{}, min, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return signal("wrong-number-of-arguments", parser.Symbol(name), len(args))
+	}
+	return nil
+}
+
+func (in *Interp) defineBuiltins() {
+	in.defineLists()
+	in.defineNumbers()
+	in.defineStrings()
+	in.defineFunctions()
+}
+
+func (in *Interp) defineLists() {
+	in.Define("car", func(args []interface{}) (interface{}, error) {
+		if err := arity("car", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return car(args[0])
+	})
+	in.Define("cdr", func(args []interface{}) (interface{}, error) {
+		if err := arity("cdr", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return cdr(args[0])
+	})
+	in.Define("cons", func(args []interface{}) (interface{}, error) {
+		if err := arity("cons", args, 2, 2); err != nil {
+			return nil, err
+		}
+		return cons(args[0], args[1]), nil
+	})
+	in.Define("list", func(args []interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, nil
+		}
+		return append([]interface{}{}, args...), nil
+	})
+	in.Define("vector", func(args []interface{}) (interface{}, error) {
+		return append(Vector{}, args...), nil
+	})
+	in.Define("length", func(args []interface{}) (interface{}, error) {
+		if err := arity("length", args, 1, 1); err != nil {
+			return nil, err
+		}
+		if s, ok := args[0].(string); ok {
+			return utf8.RuneCountInString(s), nil
+		}
+		l, err := listArg(args[0])
+		return len(l), err
+	})
+	in.Define("nth", func(args []interface{}) (interface{}, error) {
+		if err := arity("nth", args, 2, 2); err != nil {
+			return nil, err
+		}
+		n, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[1])
+		if err != nil || n < 0 || n >= len(l) {
+			return nil, err
+		}
+		return l[n], nil
+	})
+	in.Define("nthcdr", func(args []interface{}) (interface{}, error) {
+		if err := arity("nthcdr", args, 2, 2); err != nil {
+			return nil, err
+		}
+		n, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[1])
+		if err != nil || n >= len(l) {
+			return nil, err
+		}
+		if n < 0 {
+			return args[1], nil
+		}
+		return l[n:], nil
+	})
+	in.Define("aref", func(args []interface{}) (interface{}, error) {
+		if err := arity("aref", args, 2, 2); err != nil {
+			return nil, err
+		}
+		n, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := args[0].(string); ok {
+			rs := []rune(s)
+			if n < 0 || n >= len(rs) {
+				return nil, signal("args-out-of-range", s, n)
+			}
+			return int(rs[n]), nil
+		}
+		l, err := listArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 || n >= len(l) {
+			return nil, signal("args-out-of-range", args[0], n)
+		}
+		return l[n], nil
+	})
+	in.Define("append", func(args []interface{}) (interface{}, error) {
+		if len(args) == 0 {
+			return nil, nil
+		}
+		var ret []interface{}
+		for _, a := range args[:len(args)-1] {
+			l, err := listArg(a)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, l...)
+		}
+		return appendTail(ret, args[len(args)-1]), nil
+	})
+	in.Define("reverse", func(args []interface{}) (interface{}, error) {
+		if err := arity("reverse", args, 1, 1); err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[0])
+		if err != nil || len(l) == 0 {
+			return nil, err
+		}
+		ret := make([]interface{}, len(l))
+		for i, x := range l {
+			ret[len(l)-1-i] = x
+		}
+		return ret, nil
+	})
+	in.Define("nreverse", func(args []interface{}) (interface{}, error) {
+		if err := arity("nreverse", args, 1, 1); err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(l)
+		return args[0], nil
+	})
+	// sort is stable and destructive, as the merge sort of Emacs
+	in.Define("sort", func(args []interface{}) (interface{}, error) {
+		if err := arity("sort", args, 2, 2); err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var perr error
+		sort.SliceStable(l, func(i, j int) bool {
+			if perr != nil {
+				return false
+			}
+			v, err := in.apply(args[1], []interface{}{l[i], l[j]})
+			perr = err
+			return v != nil
+		})
+		return args[0], perr
+	})
+	member := func(name string, test func(a, b interface{}) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			l, err := listArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			for i, x := range l {
+				if test(args[0], x) {
+					return l[i:], nil
+				}
+			}
+			return nil, nil
+		})
+	}
+	member("memq", eq)
+	member("member", equal)
+	assoc := func(name string, test func(a, b interface{}) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			l, err := listArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			for _, x := range l {
+				if k, err := car(x); err == nil && k != nil && test(args[0], k) {
+					return x, nil
+				}
+			}
+			return nil, nil
+		})
+	}
+	assoc("assq", eq)
+	assoc("assoc", equal)
+	in.Define("plist-get", func(args []interface{}) (interface{}, error) {
+		if err := arity("plist-get", args, 2, 2); err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(l); i += 2 {
+			if eq(l[i], args[1]) {
+				return l[i+1], nil
+			}
+		}
+		return nil, nil
+	})
+	predicate := func(name string, test func(v interface{}) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 1, 1); err != nil {
+				return nil, err
+			}
+			return boolValue(test(args[0])), nil
+		})
+	}
+	isCons := func(v interface{}) bool {
+		switch v.(type) {
+		case []interface{}, parser.Cons:
+			return true
+		}
+		return false
+	}
+	predicate("null", func(v interface{}) bool { return v == nil })
+	predicate("not", func(v interface{}) bool { return v == nil })
+	predicate("consp", isCons)
+	predicate("atom", func(v interface{}) bool { return !isCons(v) })
+	predicate("listp", func(v interface{}) bool { return v == nil || isCons(v) })
+	predicate("vectorp", func(v interface{}) bool { _, ok := v.(Vector); return ok })
+	predicate("stringp", func(v interface{}) bool { _, ok := v.(string); return ok })
+	predicate("integerp", isInteger)
+	predicate("floatp", func(v interface{}) bool { _, ok := v.(float64); return ok })
+	predicate("numberp", func(v interface{}) bool { _, err := numberArg(v); return err == nil })
+	predicate("keywordp", func(v interface{}) bool { _, ok := v.(parser.Keyword); return ok })
+	predicate("symbolp", func(v interface{}) bool {
+		switch v.(type) {
+		case nil, bool, parser.Symbol, parser.Keyword:
+			return true
+		}
+		return false
+	})
+	predicate("functionp", func(v interface{}) bool {
+		if sym, ok := v.(parser.Symbol); ok {
+			v = in.funcs[string(sym)]
+		}
+		switch f := v.(type) {
+		case *subr:
+			return true
+		case *Lambda:
+			return !f.macro
+		}
+		return isLambdaForm(v)
+	})
+	compare := func(name string, test func(a, b interface{}) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			return boolValue(test(args[0], args[1])), nil
+		})
+	}
+	compare("eq", eq)
+	compare("eql", eql)
+	compare("equal", equal)
+}
+
+func car(v interface{}) (interface{}, error) {
+	switch l := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return l[0], nil
+	case parser.Cons:
+		return l.Car, nil
+	}
+	return nil, signal("wrong-type-argument", parser.Symbol("listp"), v)
+}
+
+func cdr(v interface{}) (interface{}, error) {
+	switch l := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		if len(l) == 1 {
+			return nil, nil
+		}
+		return l[1:], nil
+	case parser.Cons:
+		return l.Cdr, nil
+	}
+	return nil, signal("wrong-type-argument", parser.Symbol("listp"), v)
+}
+
+// eq compares the identity of the lists, the vectors and the bignums,
+// and the values of the others, because the Go strings have no identity.
+// The floats are never eq, as the boxed floats of Emacs.
+func eq(a, b interface{}) bool {
+	switch x := a.(type) {
+	case []interface{}:
+		y, ok := b.([]interface{})
+		return ok && len(x) == len(y) && (len(x) == 0 || &x[0] == &y[0])
+	case Vector:
+		y, ok := b.(Vector)
+		return ok && len(x) == len(y) && (len(x) == 0 || &x[0] == &y[0])
+	case float64:
+		return false
+	}
+	if a == nil || b == nil {
+		return a == b
+	}
+	if !reflect.TypeOf(a).Comparable() || !reflect.TypeOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// eql is eq, but compares the floats and the bignums by value.
+func eql(a, b interface{}) bool {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		return ok && math.Float64bits(x) == math.Float64bits(y)
+	case *big.Int:
+		y, ok := b.(*big.Int)
+		return ok && x.Cmp(y) == 0
+	}
+	return eq(a, b)
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+func (in *Interp) defineNumbers() {
+	// the integers beyond int are promoted to *big.Int, and the floats
+	// are contagious
+	arith := func(name string, unit int, fi func(a, b int) (int, bool), fb func(z, a, b *big.Int) *big.Int, ff func(a, b float64) float64) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if len(args) == 0 {
+				return unit, nil
+			}
+			acc, err := numberArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 1 && (name == "-" || name == "/") {
+				args = []interface{}{unit, acc}
+				acc = unit
+			}
+			for _, a := range args[1:] {
+				b, err := numberArg(a)
+				if err != nil {
+					return nil, err
+				}
+				if !isInteger(acc) || !isInteger(b) {
+					acc = ff(toFloat(acc), toFloat(b))
+					continue
+				}
+				if name == "/" && toFloat(b) == 0 {
+					return nil, signal("arith-error")
+				}
+				x, xok := acc.(int)
+				y, yok := b.(int)
+				if xok && yok {
+					if r, ok := fi(x, y); ok {
+						acc = r
+						continue
+					}
+				}
+				acc = normInt(fb(new(big.Int), bigInt(acc), bigInt(b)))
+			}
+			return acc, nil
+		})
+	}
+	arith("+", 0, addInt, (*big.Int).Add, func(a, b float64) float64 { return a + b })
+	arith("-", 0, subInt, (*big.Int).Sub, func(a, b float64) float64 { return a - b })
+	arith("*", 1, mulInt, (*big.Int).Mul, func(a, b float64) float64 { return a * b })
+	arith("/", 1, quoInt, (*big.Int).Quo, func(a, b float64) float64 { return a / b })
+	// extreme returns the argument itself, not converted to float
+	extreme := func(name string, wins func(c int) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 1, -1); err != nil {
+				return nil, err
+			}
+			acc, err := numberArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range args[1:] {
+				b, err := numberArg(a)
+				if err != nil {
+					return nil, err
+				}
+				if math.IsNaN(toFloat(acc)) {
+					continue
+				}
+				if math.IsNaN(toFloat(b)) || wins(compareNumbers(b, acc)) {
+					acc = b
+				}
+			}
+			return acc, nil
+		})
+	}
+	extreme("max", func(c int) bool { return c > 0 })
+	extreme("min", func(c int) bool { return c < 0 })
+	// the fixnums are int
+	in.SetVar("most-positive-fixnum", math.MaxInt)
+	in.SetVar("most-negative-fixnum", math.MinInt)
+	mod := func(name string, f func(a, b int) int) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			a, err := intArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			b, err := intArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			if b == 0 {
+				return nil, signal("arith-error")
+			}
+			return f(a, b), nil
+		})
+	}
+	mod("%", func(a, b int) int { return a % b })
+	mod("mod", func(a, b int) int {
+		if m := a % b; m != 0 && (m < 0) != (b < 0) {
+			return m + b
+		}
+		return a % b
+	})
+	step := func(name string, d int) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 1, 1); err != nil {
+				return nil, err
+			}
+			a, err := numberArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			if i, ok := a.(int); ok {
+				if r, ok := addInt(i, d); ok {
+					return r, nil
+				}
+			}
+			if isInteger(a) {
+				return normInt(new(big.Int).Add(bigInt(a), big.NewInt(int64(d)))), nil
+			}
+			return toFloat(a) + float64(d), nil
+		})
+	}
+	step("1+", 1)
+	step("1-", -1)
+	in.Define("abs", func(args []interface{}) (interface{}, error) {
+		if err := arity("abs", args, 1, 1); err != nil {
+			return nil, err
+		}
+		a, err := numberArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := a.(int); ok && i >= 0 {
+			return i, nil
+		} else if ok && i != math.MinInt {
+			return -i, nil
+		}
+		if isInteger(a) {
+			return normInt(new(big.Int).Abs(bigInt(a))), nil
+		}
+		return math.Abs(toFloat(a)), nil
+	})
+	order := func(name string, test func(c int) bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 1, -1); err != nil {
+				return nil, err
+			}
+			for i := 0; i+1 < len(args); i++ {
+				a, err := numberArg(args[i])
+				if err != nil {
+					return nil, err
+				}
+				b, err := numberArg(args[i+1])
+				if err != nil {
+					return nil, err
+				}
+				if math.IsNaN(toFloat(a)) || math.IsNaN(toFloat(b)) {
+					if name != "/=" {
+						return nil, nil
+					}
+				} else if !test(compareNumbers(a, b)) {
+					return nil, nil
+				}
+			}
+			return true, nil
+		})
+	}
+	order("=", func(c int) bool { return c == 0 })
+	order("/=", func(c int) bool { return c != 0 })
+	order("<", func(c int) bool { return c < 0 })
+	order(">", func(c int) bool { return c > 0 })
+	order("<=", func(c int) bool { return c <= 0 })
+	order(">=", func(c int) bool { return c >= 0 })
+}
+
+// addInt, subInt, mulInt and quoInt report false at the overflow.
+func addInt(a, b int) (int, bool) {
+	r := a + b
+	return r, (r > a) == (b > 0)
+}
+
+func subInt(a, b int) (int, bool) {
+	r := a - b
+	return r, (r < a) == (b > 0)
+}
+
+func mulInt(a, b int) (int, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	if (a == -1 && b == math.MinInt) || (b == -1 && a == math.MinInt) {
+		return 0, false
+	}
+	r := a * b
+	return r, r/b == a
+}
+
+func quoInt(a, b int) (int, bool) {
+	if a == math.MinInt && b == -1 {
+		return 0, false
+	}
+	return a / b, true
+}
+
+// compareNumbers compares the numbers, the integers exactly.
+func compareNumbers(a, b interface{}) int {
+	x, xok := a.(int)
+	y, yok := b.(int)
+	if xok && yok {
+		return cmp.Compare(x, y)
+	}
+	if isInteger(a) && isInteger(b) {
+		return bigInt(a).Cmp(bigInt(b))
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f
+	case float64:
+		return n
+	}
+	return math.NaN()
+}
+
+func (in *Interp) defineStrings() {
+	in.Define("concat", func(args []interface{}) (interface{}, error) {
+		var buf strings.Builder
+		for _, a := range args {
+			switch s := a.(type) {
+			case string:
+				buf.WriteString(s)
+			default:
+				l, err := listArg(a)
+				if err != nil {
+					return nil, signal("wrong-type-argument", parser.Symbol("sequencep"), a)
+				}
+				for _, c := range l {
+					r, err := intArg(c)
+					if err != nil {
+						return nil, err
+					}
+					buf.WriteRune(rune(r))
+				}
+			}
+		}
+		return buf.String(), nil
+	})
+	in.Define("substring", func(args []interface{}) (interface{}, error) {
+		if err := arity("substring", args, 1, 3); err != nil {
+			return nil, err
+		}
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		rs := []rune(s)
+		from, to := 0, len(rs)
+		for i, p := range []*int{&from, &to} {
+			if len(args) > i+1 && args[i+1] != nil {
+				if *p, err = intArg(args[i+1]); err != nil {
+					return nil, err
+				}
+				if *p < 0 {
+					*p += len(rs)
+				}
+			}
+		}
+		if from < 0 || to > len(rs) || from > to {
+			return nil, signal("args-out-of-range", s, from, to)
+		}
+		return string(rs[from:to]), nil
+	})
+	in.Define("make-string", func(args []interface{}) (interface{}, error) {
+		if err := arity("make-string", args, 2, 3); err != nil {
+			return nil, err
+		}
+		n, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, signal("wrong-type-argument", parser.Symbol("wholenump"), n)
+		}
+		c, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		if c < 0 || c > unicode.MaxRune {
+			return nil, signal("wrong-type-argument", parser.Symbol("characterp"), c)
+		}
+		return strings.Repeat(string(rune(c)), n), nil
+	})
+	in.Define("string-to-number", func(args []interface{}) (interface{}, error) {
+		if err := arity("string-to-number", args, 1, 1); err != nil {
+			return nil, err
+		}
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		s = strings.TrimSpace(s)
+		if i, err := strconv.Atoi(s); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+		return 0, nil
+	})
+	in.Define("number-to-string", func(args []interface{}) (interface{}, error) {
+		if err := arity("number-to-string", args, 1, 1); err != nil {
+			return nil, err
+		}
+		n, err := numberArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Prin1ToString(n), nil
+	})
+	convert := func(name string, f func(string) string) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 1, 1); err != nil {
+				return nil, err
+			}
+			s, err := stringArg(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return f(s), nil
+		})
+	}
+	convert("upcase", strings.ToUpper)
+	convert("downcase", strings.ToLower)
+	convert("symbol-name", func(s string) string { return s })
+	in.Define("intern", func(args []interface{}) (interface{}, error) {
+		if err := arity("intern", args, 1, 1); err != nil {
+			return nil, err
+		}
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Read(parser.AstSymbol(s)), nil
+	})
+	in.Define("string=", func(args []interface{}) (interface{}, error) {
+		if err := arity("string=", args, 2, 2); err != nil {
+			return nil, err
+		}
+		a, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := stringArg(args[1])
+		return boolValue(a == b), err
+	})
+	in.Define("string<", func(args []interface{}) (interface{}, error) {
+		if err := arity("string<", args, 2, 2); err != nil {
+			return nil, err
+		}
+		a, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := stringArg(args[1])
+		return boolValue(a < b), err
+	})
+	in.SetVar("case-fold-search", true)
+	// string-match matches the text from START, where ^ matches too. The
+	// global case-fold-search is used, without the dynamic bindings.
+	in.Define("string-match", func(args []interface{}) (interface{}, error) {
+		if err := arity("string-match", args, 2, 4); err != nil {
+			return nil, err
+		}
+		pat, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		s, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		start := 0
+		if len(args) > 2 && args[2] != nil {
+			if start, err = intArg(args[2]); err != nil {
+				return nil, err
+			}
+			n := utf8.RuneCountInString(s)
+			if start < 0 {
+				start += n
+			}
+			if start < 0 || start > n {
+				return nil, signal("args-out-of-range", s, args[2])
+			}
+		}
+		fold, ok := in.Var("case-fold-search")
+		re, err := compileRegexp(pat, !ok || fold != nil)
+		if err != nil {
+			return nil, err
+		}
+		off := len(string([]rune(s)[:start]))
+		loc := re.FindStringSubmatchIndex(s[off:])
+		if loc == nil {
+			return nil, nil
+		}
+		in.match = make([]int, len(loc))
+		for i, b := range loc {
+			in.match[i] = -1
+			if b >= 0 {
+				in.match[i] = start + utf8.RuneCountInString(s[off:off+b])
+			}
+		}
+		return in.match[0], nil
+	})
+	// matchPos returns the position of the group in the match data, or
+	// -1 for no match.
+	matchPos := func(name string, args []interface{}, max, end int) (int, error) {
+		if err := arity(name, args, 1, max); err != nil {
+			return -1, err
+		}
+		n, err := intArg(args[0])
+		if err != nil {
+			return -1, err
+		}
+		if n < 0 {
+			return -1, signal("args-out-of-range", n, 0)
+		}
+		if 2*n+end >= len(in.match) {
+			return -1, nil
+		}
+		return in.match[2*n+end], nil
+	}
+	matchBound := func(name string, end int) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			p, err := matchPos(name, args, 1, end)
+			if err != nil || p < 0 {
+				return nil, err
+			}
+			return p, nil
+		})
+	}
+	matchBound("match-beginning", 0)
+	matchBound("match-end", 1)
+	// match-string needs the string, because there are no buffers
+	in.Define("match-string", func(args []interface{}) (interface{}, error) {
+		from, err := matchPos("match-string", args, 2, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) < 2 || args[1] == nil {
+			return nil, signal("error", "match-string needs the string")
+		}
+		s, err := stringArg(args[1])
+		if err != nil || from < 0 {
+			return nil, err
+		}
+		to, _ := matchPos("match-string", args, 2, 1)
+		rs := []rune(s)
+		if to > len(rs) {
+			return nil, signal("args-out-of-range", s, from, to)
+		}
+		return string(rs[from:to]), nil
+	})
+	in.Define("split-string", func(args []interface{}) (interface{}, error) {
+		if err := arity("split-string", args, 1, 2); err != nil {
+			return nil, err
+		}
+		s, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		var parts []string
+		if len(args) > 1 && args[1] != nil {
+			sep, err := stringArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			parts = strings.Split(s, sep)
+		} else {
+			parts = strings.Fields(s)
+		}
+		var ret []interface{}
+		for _, p := range parts {
+			ret = append(ret, p)
+		}
+		if len(ret) == 0 {
+			return nil, nil
+		}
+		return ret, nil
+	})
+	in.Define("format", func(args []interface{}) (interface{}, error) {
+		if err := arity("format", args, 1, -1); err != nil {
+			return nil, err
+		}
+		f, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Format(f, args[1:]...)
+	})
+	in.Define("message", func(args []interface{}) (interface{}, error) {
+		if err := arity("message", args, 1, -1); err != nil {
+			return nil, err
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		f, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		s, err := Format(f, args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(in.Output, s)
+		return s, nil
+	})
+	in.Define("prin1-to-string", func(args []interface{}) (interface{}, error) {
+		if err := arity("prin1-to-string", args, 1, 2); err != nil {
+			return nil, err
+		}
+		if len(args) > 1 && args[1] != nil {
+			return PrincToString(args[0]), nil
+		}
+		return Prin1ToString(args[0]), nil
+	})
+	in.Define("expand-file-name", func(args []interface{}) (interface{}, error) {
+		if err := arity("expand-file-name", args, 1, 2); err != nil {
+			return nil, err
+		}
+		name, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		dir := in.Dir
+		if len(args) > 1 && args[1] != nil {
+			if dir, err = stringArg(args[1]); err != nil {
+				return nil, err
+			}
+		}
+		if filepath.IsAbs(name) {
+			return filepath.Clean(name), nil
+		}
+		return filepath.Join(dir, name), nil
+	})
+}
+
+func (in *Interp) defineFunctions() {
+	in.Define("funcall", func(args []interface{}) (interface{}, error) {
+		if err := arity("funcall", args, 1, -1); err != nil {
+			return nil, err
+		}
+		return in.apply(args[0], args[1:])
+	})
+	in.Define("apply", func(args []interface{}) (interface{}, error) {
+		if err := arity("apply", args, 1, -1); err != nil {
+			return nil, err
+		}
+		fargs := append([]interface{}{}, args[1:]...)
+		if len(fargs) > 0 {
+			last, err := listArg(fargs[len(fargs)-1])
+			if err != nil {
+				return nil, err
+			}
+			fargs = append(fargs[:len(fargs)-1], last...)
+		}
+		return in.apply(args[0], fargs)
+	})
+	mapper := func(name string, collect bool) {
+		in.Define(name, func(args []interface{}) (interface{}, error) {
+			if err := arity(name, args, 2, 2); err != nil {
+				return nil, err
+			}
+			l, err := listArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			var ret []interface{}
+			for _, x := range l {
+				v, err := in.apply(args[0], []interface{}{x})
+				if err != nil {
+					return nil, err
+				}
+				if collect {
+					ret = append(ret, v)
+				}
+			}
+			if !collect {
+				return args[1], nil
+			}
+			if len(ret) == 0 {
+				return nil, nil
+			}
+			return ret, nil
+		})
+	}
+	mapper("mapcar", true)
+	mapper("mapc", false)
+	in.Define("mapconcat", func(args []interface{}) (interface{}, error) {
+		if err := arity("mapconcat", args, 2, 3); err != nil {
+			return nil, err
+		}
+		l, err := listArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		sep := ""
+		if len(args) > 2 {
+			if sep, err = stringArg(args[2]); err != nil {
+				return nil, err
+			}
+		}
+		parts := make([]string, len(l))
+		for i, x := range l {
+			v, err := in.apply(args[0], []interface{}{x})
+			if err != nil {
+				return nil, err
+			}
+			if parts[i], err = stringArg(v); err != nil {
+				return nil, err
+			}
+		}
+		return strings.Join(parts, sep), nil
+	})
+	in.Define("identity", func(args []interface{}) (interface{}, error) {
+		if err := arity("identity", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return args[0], nil
+	})
+	in.Define("eval", func(args []interface{}) (interface{}, error) {
+		if err := arity("eval", args, 1, 2); err != nil {
+			return nil, err
+		}
+		return in.eval(args[0], nil)
+	})
+	in.Define("error", func(args []interface{}) (interface{}, error) {
+		if err := arity("error", args, 1, -1); err != nil {
+			return nil, err
+		}
+		f, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		msg, err := Format(f, args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		return nil, signal("error", msg)
+	})
+	in.Define("signal", func(args []interface{}) (interface{}, error) {
+		if err := arity("signal", args, 2, 2); err != nil {
+			return nil, err
+		}
+		sym, err := symbolArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		data, err := listArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, signal(sym, data...)
+	})
+	in.Define("throw", func(args []interface{}) (interface{}, error) {
+		if err := arity("throw", args, 2, 2); err != nil {
+			return nil, err
+		}
+		return nil, &throw{tag: args[0], value: args[1]}
+	})
+	features := map[string]bool{}
+	in.Define("provide", func(args []interface{}) (interface{}, error) {
+		if err := arity("provide", args, 1, 2); err != nil {
+			return nil, err
+		}
+		name, err := symbolArg(args[0])
+		features[name] = true
+		return args[0], err
+	})
+	// require succeeds for the features the interpreter stands in for
+	in.Define("require", func(args []interface{}) (interface{}, error) {
+		if err := arity("require", args, 1, 3); err != nil {
+			return nil, err
+		}
+		return args[0], nil
+	})
+	in.Define("featurep", func(args []interface{}) (interface{}, error) {
+		if err := arity("featurep", args, 1, 2); err != nil {
+			return nil, err
+		}
+		name, err := symbolArg(args[0])
+		return boolValue(features[name]), err
+	})
+}
+
+// Prin1ToString returns the printed representation of the value read
+// back by the reader, such as "\"text\"" for a string.
+func Prin1ToString(v interface{}) string {
+	var buf bytes.Buffer
+	printValue(&buf, v, true)
+	return buf.String()
+}
+
+// PrincToString returns the printed representation for the humans,
+// such as "text" for a string.
+func PrincToString(v interface{}) string {
+	var buf bytes.Buffer
+	printValue(&buf, v, false)
+	return buf.String()
+}
+
+func printValue(buf *bytes.Buffer, v interface{}, escape bool) {
+	switch x := v.(type) {
+	case nil:
+		buf.WriteString("nil")
+	case bool:
+		if x {
+			buf.WriteString("t")
+		} else {
+			buf.WriteString("nil")
+		}
+	case int:
+		buf.WriteString(strconv.Itoa(x))
+	case float64:
+		buf.WriteString(parser.FloatLiteral(x, 64))
+	case *big.Int:
+		buf.WriteString(x.String())
+	case string:
+		if escape {
+			buf.WriteString(parser.StringLiteral(x))
+		} else {
+			buf.WriteString(x)
+		}
+	case parser.Symbol:
+		if lit, err := parser.SymbolLiteralChecked(string(x)); escape && err == nil {
+			buf.WriteString(lit)
+		} else {
+			buf.WriteString(string(x)) // the raw bytes of an invalid name too
+		}
+	case parser.Keyword:
+		buf.WriteString(":" + string(x))
+	case []interface{}:
+		buf.WriteByte('(')
+		printElements(buf, x, escape)
+		buf.WriteByte(')')
+	case parser.Cons:
+		buf.WriteByte('(')
+		for {
+			printValue(buf, x.Car, escape)
+			switch next := x.Cdr.(type) {
+			case parser.Cons:
+				buf.WriteByte(' ')
+				x = next
+				continue
+			case []interface{}:
+				buf.WriteByte(' ')
+				printElements(buf, next, escape)
+			case nil:
+			default:
+				buf.WriteString(" . ")
+				printValue(buf, next, escape)
+			}
+			break
+		}
+		buf.WriteByte(')')
+	case Vector:
+		buf.WriteByte('[')
+		printElements(buf, x, escape)
+		buf.WriteByte(']')
+	case *Lambda:
+		if x.name != "" {
+			buf.WriteString("#<function " + x.name + ">")
+		} else {
+			buf.WriteString("#<lambda>")
+		}
+	case *subr:
+		buf.WriteString("#<subr " + x.name + ">")
+	default:
+		if b, err := elrpc.Encode(v); err == nil {
+			buf.Write(b)
+		} else {
+			fmt.Fprintf(buf, "#<%T>", v)
+		}
+	}
+}
+
+func printElements(buf *bytes.Buffer, vs []interface{}, escape bool) {
+	for i, v := range vs {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		printValue(buf, v, escape)
+	}
+}
+
+// Format formats the values like format of Emacs Lisp: %s, %S, %d, %o,
+// %x, %X, %c, %f, %e, %g and %% with the flags, the width and the
+// precision.
+func Format(f string, args ...interface{}) (string, error) {
+	var buf strings.Builder
+	n := 0
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			buf.WriteByte(f[i])
+			continue
+		}
+		j := i + 1
+		for j < len(f) && strings.IndexByte("+- #0123456789.", f[j]) >= 0 {
+			j++
+		}
+		if j >= len(f) {
+			return "", signal("error", "Format string ends in middle of format specifier")
+		}
+		spec, verb := f[i:j], f[j]
+		i = j
+		if verb == '%' {
+			buf.WriteByte('%')
+			continue
+		}
+		if n >= len(args) {
+			return "", signal("error", "Not enough arguments for format string")
+		}
+		arg := args[n]
+		n++
+		switch verb {
+		case 's', 'S':
+			s := Prin1ToString(arg)
+			if verb == 's' {
+				s = PrincToString(arg)
+			}
+			if dot := strings.IndexByte(spec, '.'); dot >= 0 {
+				if p, err := strconv.Atoi(spec[dot+1:]); err == nil && p < utf8.RuneCountInString(s) {
+					s = string([]rune(s)[:p])
+				}
+				spec = spec[:dot]
+			}
+			fmt.Fprintf(&buf, spec+"s", s)
+		case 'd', 'o', 'x', 'X':
+			num, err := numberArg(arg)
+			if err != nil {
+				return "", err
+			}
+			if fl, ok := num.(float64); ok {
+				num = int(fl)
+			}
+			fmt.Fprintf(&buf, spec+string(verb), num)
+		case 'c':
+			c, err := intArg(arg)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&buf, spec+"c", rune(c))
+		case 'f', 'e', 'g':
+			num, err := numberArg(arg)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&buf, spec+string(verb), toFloat(num))
+		default:
+			return "", signal("error", "Invalid format operation %"+string(verb))
+		}
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,262 @@
+package interp
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/kiwanami/go-elrpc"
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+// EPCStarter starts the peer of epc:start-epc, the program and the
+// arguments, and returns the connected service.
+type EPCStarter func(cmd []string) (elrpc.Service, error)
+
+// deferred is the result of the deferred functions. The interpreter calls
+// the peer synchronously, so it holds the value or the error already.
+type deferred struct {
+	value interface{}
+	err   error
+}
+
+// BindEPC defines the client functions of epc.el and the deferred.el
+// functions they are chained with:
+//
+//	epc:start-epc epc:stop-epc epc:live-p epc:call-sync epc:call-deferred
+//	epc:query-methods-sync epc:query-methods-deferred epc:sync
+//	deferred:$ deferred:nextc deferred:error deferred:sync!
+//
+// The deferred tasks run at once. An error skips the following
+// deferred:nextc callbacks until deferred:error or epc:sync. If start is
+// nil, elrpc.StartProcess starts the program.
+func (in *Interp) BindEPC(start EPCStarter) {
+	if start == nil {
+		start = func(cmd []string) (elrpc.Service, error) {
+			return elrpc.StartProcess(cmd, nil)
+		}
+	}
+	in.Define("epc:start-epc", func(args []interface{}) (interface{}, error) {
+		if err := arity("epc:start-epc", args, 1, 2); err != nil {
+			return nil, err
+		}
+		program, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		cmd := []string{program}
+		if len(args) > 1 {
+			l, err := listArg(args[1])
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range l {
+				s, err := stringArg(a)
+				if err != nil {
+					return nil, err
+				}
+				cmd = append(cmd, s)
+			}
+		}
+		svc, err := start(cmd)
+		if err != nil {
+			return nil, signal("epc-error", err.Error())
+		}
+		return svc, nil
+	})
+	in.Define("epc:stop-epc", func(args []interface{}) (interface{}, error) {
+		svc, err := serviceArg("epc:stop-epc", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return nil, epcSignal(svc.Stop())
+	})
+	in.Define("epc:live-p", func(args []interface{}) (interface{}, error) {
+		svc, err := serviceArg("epc:live-p", args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(svc.IsRunning()), nil
+	})
+	in.Define("epc:call-sync", func(args []interface{}) (interface{}, error) {
+		return epcCall("epc:call-sync", args)
+	})
+	in.Define("epc:call-deferred", func(args []interface{}) (interface{}, error) {
+		v, err := epcCall("epc:call-deferred", args)
+		return &deferred{value: v, err: err}, nil
+	})
+	in.Define("epc:query-methods-sync", func(args []interface{}) (interface{}, error) {
+		return epcQueryMethods(args)
+	})
+	in.Define("epc:query-methods-deferred", func(args []interface{}) (interface{}, error) {
+		v, err := epcQueryMethods(args)
+		return &deferred{value: v, err: err}, nil
+	})
+	in.Define("epc:sync", func(args []interface{}) (interface{}, error) {
+		if err := arity("epc:sync", args, 2, 2); err != nil {
+			return nil, err
+		}
+		return syncDeferred(args[1])
+	})
+	in.Define("deferred:sync!", func(args []interface{}) (interface{}, error) {
+		if err := arity("deferred:sync!", args, 1, 1); err != nil {
+			return nil, err
+		}
+		return syncDeferred(args[0])
+	})
+	in.Define("deferred:nextc", func(args []interface{}) (interface{}, error) {
+		if err := arity("deferred:nextc", args, 2, 2); err != nil {
+			return nil, err
+		}
+		d := toDeferred(args[0])
+		if d.err != nil {
+			return d, nil
+		}
+		v, err := in.apply(args[1], []interface{}{d.value})
+		return &deferred{value: v, err: err}, nil
+	})
+	in.Define("deferred:error", func(args []interface{}) (interface{}, error) {
+		if err := arity("deferred:error", args, 2, 2); err != nil {
+			return nil, err
+		}
+		d := toDeferred(args[0])
+		if d.err == nil {
+			return d, nil
+		}
+		e := toSignal(d.err)
+		v, err := in.apply(args[1], []interface{}{append([]interface{}{e.Symbol}, e.Data...)})
+		return &deferred{value: v, err: err}, nil
+	})
+	in.funcs["deferred:$"] = specialForm(formDeferredChain)
+}
+
+// formDeferredChain evaluates the forms binding the previous result to it.
+func formDeferredChain(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	var ret interface{}
+	for _, x := range args {
+		ne := &env{vars: map[string]interface{}{"it": ret}, parent: e}
+		var err error
+		if ret, err = in.eval(x, ne); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func toDeferred(v interface{}) *deferred {
+	if d, ok := v.(*deferred); ok {
+		return d
+	}
+	return &deferred{value: v}
+}
+
+func syncDeferred(v interface{}) (interface{}, error) {
+	d := toDeferred(v)
+	return d.value, d.err
+}
+
+func serviceArg(name string, args []interface{}, min int) (elrpc.Service, error) {
+	if err := arity(name, args, min, -1); err != nil {
+		return nil, err
+	}
+	svc, ok := args[0].(elrpc.Service)
+	if !ok {
+		return nil, signal("wrong-type-argument", parser.Symbol("epc:manager-p"), args[0])
+	}
+	return svc, nil
+}
+
+func epcCall(name string, args []interface{}) (interface{}, error) {
+	svc, err := serviceArg(name, args, 3)
+	if err != nil {
+		return nil, err
+	}
+	method, err := stringArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+	margs, err := listArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+	ret, err := svc.CallContext(context.Background(), method, margs...)
+	if err != nil {
+		return nil, epcSignal(err)
+	}
+	return FromGo(ret), nil
+}
+
+func epcQueryMethods(args []interface{}) (interface{}, error) {
+	svc, err := serviceArg("epc:query-methods", args, 1)
+	if err != nil {
+		return nil, err
+	}
+	ms, err := svc.QueryMethods()
+	if err != nil {
+		return nil, epcSignal(err)
+	}
+	var ret []interface{}
+	for _, m := range ms {
+		ret = append(ret, list(parser.Symbol(m.Name), m.Argdoc, m.Docstring))
+	}
+	return ret, nil
+}
+
+func epcSignal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return signal("epc-error", err.Error())
+}
+
+// FromGo transforms the Go value, such as a result of the peer, into the
+// interpreter's value: the slices into lists, the numbers into int or
+// float64, false into nil and the maps into alists sorted by the keys.
+func FromGo(v interface{}) interface{} {
+	switch x := v.(type) {
+	case nil, int, float64, string, parser.Symbol, parser.Keyword, *big.Int, Vector:
+		return v
+	case bool:
+		return boolValue(x)
+	case parser.Cons:
+		return cons(FromGo(x.Car), FromGo(x.Cdr))
+	case *parser.Cons:
+		return cons(FromGo(x.Car), FromGo(x.Cdr))
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice, reflect.Array:
+		if rv.Len() == 0 {
+			return nil
+		}
+		ret := make([]interface{}, rv.Len())
+		for i := range ret {
+			ret[i] = FromGo(rv.Index(i).Interface())
+		}
+		return ret
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return nil
+		}
+		ret := make([]interface{}, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			ret = append(ret, cons(FromGo(k.Interface()), FromGo(rv.MapIndex(k).Interface())))
+		}
+		sort.Slice(ret, func(i, j int) bool {
+			a, _ := car(ret[i])
+			b, _ := car(ret[j])
+			return Prin1ToString(a) < Prin1ToString(b)
+		})
+		return ret
+	}
+	return v
+}
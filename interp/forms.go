@@ -0,0 +1,518 @@
+package interp
+
+import (
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+var specialForms = map[string]specialForm{
+	"quote":          formQuote,
+	"function":       formFunction,
+	"`":              formBackquote,
+	"progn":          formProgn,
+	"prog1":          formProg1,
+	"if":             formIf,
+	"cond":           formCond,
+	"and":            formAnd,
+	"or":             formOr,
+	"when":           formWhen,
+	"unless":         formUnless,
+	"while":          formWhile,
+	"let":            formLet,
+	"let*":           formLetStar,
+	"setq":           formSetq,
+	"lambda":         formLambda,
+	"defun":          formDefun,
+	"defmacro":       formDefmacro,
+	"defvar":         formDefvar,
+	"defconst":       formDefconst,
+	"dolist":         formDolist,
+	"dotimes":        formDotimes,
+	"condition-case": formConditionCase,
+	"unwind-protect": formUnwindProtect,
+	"catch":          formCatch,
+	"interactive":    formInteractive,
+}
+
+func symbolArg(x interface{}) (string, error) {
+	s, ok := x.(parser.Symbol)
+	if !ok {
+		return "", signal("wrong-type-argument", parser.Symbol("symbolp"), x)
+	}
+	return string(s), nil
+}
+
+func formQuote(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("quote"), len(args))
+	}
+	return args[0], nil
+}
+
+func formFunction(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("function"), len(args))
+	}
+	if isLambdaForm(args[0]) {
+		return makeLambda("", args[0].([]interface{})[1:], e), nil
+	}
+	return args[0], nil
+}
+
+func formLambda(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return makeLambda("", args, e), nil
+}
+
+func formBackquote(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("`"), len(args))
+	}
+	return in.backquote(args[0], e, 1)
+}
+
+// backquote expands the template. depth is the nesting level of the
+// backquotes; only the unquotes of the outermost one are evaluated.
+func (in *Interp) backquote(x interface{}, e *env, depth int) (interface{}, error) {
+	switch x := x.(type) {
+	case []interface{}:
+		if len(x) == 2 {
+			switch x[0] {
+			case parser.Symbol(","), parser.Symbol(",@"):
+				if depth == 1 {
+					return in.eval(x[1], e)
+				}
+				v, err := in.backquote(x[1], e, depth-1)
+				return list(x[0], v), err
+			case parser.Symbol("`"):
+				v, err := in.backquote(x[1], e, depth+1)
+				return list(x[0], v), err
+			}
+		}
+		var ret []interface{}
+		for i := 0; i < len(x); i++ {
+			// (a . ,b) is read as (a \, b)
+			if x[i] == parser.Symbol(",") && i > 0 && i == len(x)-2 && depth == 1 {
+				tail, err := in.eval(x[i+1], e)
+				if err != nil {
+					return nil, err
+				}
+				return appendTail(ret, tail), nil
+			}
+			if l, ok := x[i].([]interface{}); ok && len(l) == 2 && l[0] == parser.Symbol(",@") && depth == 1 {
+				v, err := in.eval(l[1], e)
+				if err != nil {
+					return nil, err
+				}
+				vs, err := listArg(v)
+				if err != nil {
+					return nil, err
+				}
+				ret = append(ret, vs...)
+				continue
+			}
+			v, err := in.backquote(x[i], e, depth)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		if len(ret) == 0 {
+			return nil, nil
+		}
+		return ret, nil
+	case parser.Cons:
+		car, err := in.backquote(x.Car, e, depth)
+		if err != nil {
+			return nil, err
+		}
+		cdr, err := in.backquote(x.Cdr, e, depth)
+		if err != nil {
+			return nil, err
+		}
+		return cons(car, cdr), nil
+	case Vector:
+		l, err := in.backquote([]interface{}(x), e, depth)
+		if err != nil {
+			return nil, err
+		}
+		vs, _ := l.([]interface{})
+		return Vector(vs), nil
+	}
+	return x, nil
+}
+
+func appendTail(elements []interface{}, tail interface{}) interface{} {
+	for i := len(elements) - 1; i >= 0; i-- {
+		tail = cons(elements[i], tail)
+	}
+	return tail
+}
+
+func formProgn(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return in.progn(args, e)
+}
+
+func formProg1(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("prog1"), 0)
+	}
+	ret, err := in.eval(args[0], e)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := in.progn(args[1:], e); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func formIf(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("if"), len(args))
+	}
+	c, err := in.eval(args[0], e)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		return in.eval(args[1], e)
+	}
+	return in.progn(args[2:], e)
+}
+
+func formCond(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	for _, clause := range args {
+		cl, ok := clause.([]interface{})
+		if !ok || len(cl) == 0 {
+			continue
+		}
+		c, err := in.eval(cl[0], e)
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			if len(cl) == 1 {
+				return c, nil
+			}
+			return in.progn(cl[1:], e)
+		}
+	}
+	return nil, nil
+}
+
+func formAnd(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	var ret interface{} = true
+	for _, x := range args {
+		var err error
+		if ret, err = in.eval(x, e); err != nil || ret == nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func formOr(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	for _, x := range args {
+		if ret, err := in.eval(x, e); err != nil || ret != nil {
+			return ret, err
+		}
+	}
+	return nil, nil
+}
+
+func formWhen(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("when"), 0)
+	}
+	c, err := in.eval(args[0], e)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return in.progn(args[1:], e)
+}
+
+func formUnless(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("unless"), 0)
+	}
+	c, err := in.eval(args[0], e)
+	if err != nil || c != nil {
+		return nil, err
+	}
+	return in.progn(args[1:], e)
+}
+
+func formWhile(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("while"), 0)
+	}
+	for {
+		c, err := in.eval(args[0], e)
+		if err != nil || c == nil {
+			return nil, err
+		}
+		if _, err := in.progn(args[1:], e); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// binding returns the variable name and the value form of a let binding.
+func binding(b interface{}) (string, interface{}, error) {
+	if l, ok := b.([]interface{}); ok && len(l) > 0 {
+		name, err := symbolArg(l[0])
+		if err != nil || len(l) == 1 {
+			return name, nil, err
+		}
+		return name, l[1], nil
+	}
+	name, err := symbolArg(b)
+	return name, nil, err
+}
+
+func formLet(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("let"), 0)
+	}
+	bindings, _ := args[0].([]interface{})
+	ne := &env{vars: make(map[string]interface{}, len(bindings)), parent: e}
+	for _, b := range bindings {
+		name, x, err := binding(b)
+		if err != nil {
+			return nil, err
+		}
+		if ne.vars[name], err = in.eval(x, e); err != nil {
+			return nil, err
+		}
+	}
+	return in.progn(args[1:], ne)
+}
+
+func formLetStar(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("let*"), 0)
+	}
+	bindings, _ := args[0].([]interface{})
+	for _, b := range bindings {
+		name, x, err := binding(b)
+		if err != nil {
+			return nil, err
+		}
+		v, err := in.eval(x, e)
+		if err != nil {
+			return nil, err
+		}
+		e = &env{vars: map[string]interface{}{name: v}, parent: e}
+	}
+	return in.progn(args[1:], e)
+}
+
+func formSetq(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args)%2 != 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("setq"), len(args))
+	}
+	var ret interface{}
+	for i := 0; i < len(args); i += 2 {
+		name, err := symbolArg(args[i])
+		if err != nil {
+			return nil, err
+		}
+		if ret, err = in.eval(args[i+1], e); err != nil {
+			return nil, err
+		}
+		in.setVar(name, ret, e)
+	}
+	return ret, nil
+}
+
+func formDefun(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return in.defineLambda("defun", args, e, false)
+}
+
+func formDefmacro(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return in.defineLambda("defmacro", args, e, true)
+}
+
+func (in *Interp) defineLambda(form string, args []interface{}, e *env, macro bool) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol(form), len(args))
+	}
+	name, err := symbolArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	f := makeLambda(name, args[1:], e)
+	f.macro = macro
+	in.funcs[name] = f
+	return args[0], nil
+}
+
+func formDefvar(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return in.defineVar("defvar", args, e, false)
+}
+
+func formDefconst(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return in.defineVar("defconst", args, e, true)
+}
+
+func (in *Interp) defineVar(form string, args []interface{}, e *env, always bool) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol(form), 0)
+	}
+	name, err := symbolArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, bound := in.globals[name]; len(args) > 1 && (always || !bound) {
+		v, err := in.eval(args[1], e)
+		if err != nil {
+			return nil, err
+		}
+		in.globals[name] = v
+	}
+	return args[0], nil
+}
+
+// loopSpec returns the variable, the evaluated value and the result form
+// of dolist and dotimes.
+func (in *Interp) loopSpec(form string, args []interface{}, e *env) (string, interface{}, interface{}, error) {
+	spec, ok := args[0].([]interface{})
+	if !ok || len(spec) < 2 {
+		return "", nil, nil, signal("wrong-type-argument", parser.Symbol(form), args[0])
+	}
+	name, err := symbolArg(spec[0])
+	if err != nil {
+		return "", nil, nil, err
+	}
+	v, err := in.eval(spec[1], e)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	var result interface{}
+	if len(spec) > 2 {
+		result = spec[2]
+	}
+	return name, v, result, nil
+}
+
+func formDolist(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("dolist"), 0)
+	}
+	name, v, result, err := in.loopSpec("dolist", args, e)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := listArg(v)
+	if err != nil {
+		return nil, err
+	}
+	ne := &env{vars: map[string]interface{}{}, parent: e}
+	for _, x := range elements {
+		ne.vars[name] = x
+		if _, err := in.progn(args[1:], ne); err != nil {
+			return nil, err
+		}
+	}
+	ne.vars[name] = nil
+	return in.eval(result, ne)
+}
+
+func formDotimes(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("dotimes"), 0)
+	}
+	name, v, result, err := in.loopSpec("dotimes", args, e)
+	if err != nil {
+		return nil, err
+	}
+	n, ok := v.(int)
+	if !ok {
+		return nil, signal("wrong-type-argument", parser.Symbol("integerp"), v)
+	}
+	ne := &env{vars: map[string]interface{}{}, parent: e}
+	for i := 0; i < n; i++ {
+		ne.vars[name] = i
+		if _, err := in.progn(args[1:], ne); err != nil {
+			return nil, err
+		}
+	}
+	ne.vars[name] = n
+	return in.eval(result, ne)
+}
+
+func formConditionCase(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) < 2 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("condition-case"), len(args))
+	}
+	name, _ := args[0].(parser.Symbol) // nil for no variable
+	ret, err := in.eval(args[1], e)
+	if err == nil {
+		return ret, nil
+	}
+	if _, ok := err.(*throw); ok {
+		return nil, err
+	}
+	sig := toSignal(err)
+	for _, h := range args[2:] {
+		hl, ok := h.([]interface{})
+		if !ok || len(hl) == 0 || !handles(hl[0], sig.Symbol) {
+			continue
+		}
+		ne := e
+		if name != "" {
+			ne = &env{vars: map[string]interface{}{
+				string(name): append([]interface{}{sig.Symbol}, sig.Data...),
+			}, parent: e}
+		}
+		return in.progn(hl[1:], ne)
+	}
+	return nil, err
+}
+
+// handles reports whether the condition of the handler catches the
+// signal. All signals are errors here.
+func handles(cond interface{}, sym parser.Symbol) bool {
+	switch c := cond.(type) {
+	case parser.Symbol:
+		return c == sym || c == "error" || c == "t"
+	case bool:
+		return c
+	case []interface{}:
+		for _, x := range c {
+			if handles(x, sym) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func formUnwindProtect(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("unwind-protect"), 0)
+	}
+	ret, err := in.eval(args[0], e)
+	if _, uerr := in.progn(args[1:], e); uerr != nil {
+		return nil, uerr
+	}
+	return ret, err
+}
+
+func formCatch(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, signal("wrong-number-of-arguments", parser.Symbol("catch"), 0)
+	}
+	tag, err := in.eval(args[0], e)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := in.progn(args[1:], e)
+	if t, ok := err.(*throw); ok && eq(t.tag, tag) {
+		return t.value, nil
+	}
+	return ret, err
+}
+
+func formInteractive(in *Interp, args []interface{}, e *env) (interface{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,403 @@
+// Package interp is a small Emacs Lisp interpreter on the parser AST.
+// It evaluates the elisp side of EPC, such as examples/calc/client.el,
+// in tests and scripts without a real Emacs.
+//
+// The values are the Go values of the parser package read with the
+// PreserveSymbols and PreserveCons options: nil, true for t, int,
+// float64, string, Symbol, Keyword, proper lists as []interface{},
+// dotted pairs as Cons, and Vector. The variables are lexically bound.
+//
+// The lists are not made of cons cells, which differs from Emacs: cons
+// copies the list, so it takes O(n) and the result does not share the
+// tail, as (eq (cdr (cons 0 a)) a) is nil. The destructive functions,
+// such as nreverse and sort, rearrange the elements in place instead of
+// relinking the cells, so the code should use their results.
+package interp
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+// Vector is an elisp vector. The proper lists are []interface{}.
+type Vector []interface{}
+
+// Primitive is a function implemented in Go.
+type Primitive func(args []interface{}) (interface{}, error)
+
+type subr struct {
+	name string
+	fn   Primitive
+}
+
+// Lambda is a closure created by lambda, defun or defmacro.
+type Lambda struct {
+	name   string
+	params []string // with &optional and &rest
+	body   []interface{}
+	env    *env
+	macro  bool
+}
+
+type specialForm func(in *Interp, args []interface{}, e *env) (interface{}, error)
+
+// env is a lexical environment. The nil env is the global one.
+type env struct {
+	vars   map[string]interface{}
+	parent *env
+}
+
+func (e *env) lookup(name string) (*env, bool) {
+	for ; e != nil; e = e.parent {
+		if _, ok := e.vars[name]; ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+const maxDepth = 1000
+
+// Interp is an interpreter with the global variables and functions.
+// It is not safe for concurrent use.
+type Interp struct {
+	globals map[string]interface{}
+	funcs   map[string]interface{} // *Lambda, *subr or specialForm
+	depth   int
+	match   []int // match data of the last string-match, in characters
+
+	Output io.Writer // output of message, os.Stderr by default
+	Dir    string    // default-directory for expand-file-name
+}
+
+// New returns an interpreter with the special forms and primitives.
+func New() *Interp {
+	in := &Interp{
+		globals: map[string]interface{}{},
+		funcs:   map[string]interface{}{},
+		Output:  os.Stderr,
+	}
+	in.Dir, _ = os.Getwd()
+	for name, f := range specialForms {
+		in.funcs[name] = f
+	}
+	in.defineBuiltins()
+	return in
+}
+
+// Define defines a Go function.
+func (in *Interp) Define(name string, f Primitive) {
+	in.funcs[name] = &subr{name: name, fn: f}
+}
+
+// SetVar sets a global variable.
+func (in *Interp) SetVar(name string, v interface{}) {
+	in.globals[name] = v
+}
+
+// Var returns the value of a global variable.
+func (in *Interp) Var(name string) (interface{}, bool) {
+	v, ok := in.globals[name]
+	return v, ok
+}
+
+// EvalString reads and evaluates the forms, and returns the last value.
+func (in *Interp) EvalString(src string) (interface{}, error) {
+	sexps, perr := parser.Parse(src)
+	if perr != nil {
+		return nil, perr
+	}
+	var ret interface{}
+	for _, s := range sexps {
+		var err error
+		if ret, err = in.Eval(s); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// LoadFile evaluates the forms in the file, like load.
+func (in *Interp) LoadFile(path string) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	_, err = in.EvalString(string(src))
+	return err
+}
+
+// Eval evaluates the S-expression in the global environment.
+func (in *Interp) Eval(s parser.SExp) (interface{}, error) {
+	return in.eval(Read(s), nil)
+}
+
+// Funcall calls the function, a symbol or a function value, with the
+// evaluated arguments.
+func (in *Interp) Funcall(f interface{}, args ...interface{}) (interface{}, error) {
+	return in.apply(f, args)
+}
+
+var readOptions = &parser.ValueOptions{PreserveSymbols: true, PreserveCons: true}
+
+// Read transforms the S-expression into the value, the code for Eval.
+func Read(s parser.SExp) interface{} {
+	switch s := s.(type) {
+	case *parser.SExpList:
+		return readList(s.Elements(), nil)
+	case *parser.SExpListDot:
+		return readList(s.Elements(), Read(s.Last()))
+	case *parser.SExpCons:
+		return cons(Read(s.Car()), Read(s.Cdr()))
+	case *parser.SExpVector:
+		v := make(Vector, len(s.Elements()))
+		for i, e := range s.Elements() {
+			v[i] = Read(e)
+		}
+		return v
+	case *parser.SExpQuoted:
+		if s.IsFunction() {
+			return list(parser.Symbol("function"), Read(s.Inner()))
+		}
+		return list(parser.Symbol("quote"), Read(s.Inner()))
+	case *parser.SExpQuasiQuoted:
+		return list(parser.Symbol("`"), Read(s.Inner()))
+	case *parser.SExpUnquote:
+		if s.IsSplice() {
+			return list(parser.Symbol(",@"), Read(s.Inner()))
+		}
+		return list(parser.Symbol(","), Read(s.Inner()))
+	case *parser.SExpLabel:
+		return Read(s.Inner())
+	case *parser.SExpLabelRef:
+		if t := s.Target(); t != nil {
+			return Read(t)
+		}
+		return nil
+	}
+	return s.ToValueWith(readOptions)
+}
+
+func readList(elements []parser.SExp, last interface{}) interface{} {
+	ret := last
+	for i := len(elements) - 1; i >= 0; i-- {
+		ret = cons(Read(elements[i]), ret)
+	}
+	return ret
+}
+
+func list(vs ...interface{}) []interface{} {
+	return vs
+}
+
+// cons keeps the proper lists in slices, copying the tail.
+func cons(car, cdr interface{}) interface{} {
+	switch l := cdr.(type) {
+	case nil:
+		return []interface{}{car}
+	case []interface{}:
+		return append([]interface{}{car}, l...)
+	}
+	return parser.Cons{Car: car, Cdr: cdr}
+}
+
+func (in *Interp) eval(x interface{}, e *env) (interface{}, error) {
+	switch x := x.(type) {
+	case parser.Symbol:
+		name := string(x)
+		if be, ok := e.lookup(name); ok {
+			return be.vars[name], nil
+		}
+		if v, ok := in.globals[name]; ok {
+			return v, nil
+		}
+		return nil, signal("void-variable", x)
+	case []interface{}:
+		if len(x) == 0 {
+			return nil, nil
+		}
+		return in.evalForm(x, e)
+	}
+	return x, nil // self-evaluating
+}
+
+func (in *Interp) evalForm(x []interface{}, e *env) (interface{}, error) {
+	in.depth++
+	defer func() { in.depth-- }()
+	if in.depth > maxDepth {
+		return nil, signal("excessive-lisp-nesting", maxDepth)
+	}
+	var f interface{} = x[0]
+	if sym, ok := x[0].(parser.Symbol); ok {
+		if f, ok = in.funcs[string(sym)]; !ok {
+			return nil, signal("void-function", sym)
+		}
+		switch f := f.(type) {
+		case specialForm:
+			return f(in, x[1:], e)
+		case *Lambda:
+			if f.macro {
+				expanded, err := in.call(f, x[1:])
+				if err != nil {
+					return nil, err
+				}
+				return in.eval(expanded, e)
+			}
+		}
+	} else if isLambdaForm(f) {
+		f = makeLambda("", f.([]interface{})[1:], e)
+	}
+	args := make([]interface{}, len(x)-1)
+	for i, a := range x[1:] {
+		v, err := in.eval(a, e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return in.apply(f, args)
+}
+
+func isLambdaForm(x interface{}) bool {
+	l, ok := x.([]interface{})
+	return ok && len(l) > 1 && l[0] == parser.Symbol("lambda")
+}
+
+func (in *Interp) apply(f interface{}, args []interface{}) (interface{}, error) {
+	switch fn := f.(type) {
+	case parser.Symbol:
+		g, ok := in.funcs[string(fn)]
+		if !ok {
+			return nil, signal("void-function", fn)
+		}
+		if _, ok := g.(specialForm); ok {
+			return nil, signal("invalid-function", fn)
+		}
+		return in.apply(g, args)
+	case *subr:
+		return fn.fn(args)
+	case *Lambda:
+		if fn.macro {
+			return nil, signal("invalid-function", fn)
+		}
+		return in.call(fn, args)
+	case []interface{}:
+		if isLambdaForm(fn) {
+			return in.call(makeLambda("", fn[1:], nil), args)
+		}
+	}
+	return nil, signal("invalid-function", f)
+}
+
+func (in *Interp) call(f *Lambda, args []interface{}) (interface{}, error) {
+	vars := make(map[string]interface{}, len(f.params))
+	optional := false
+	i := 0
+	for j := 0; j < len(f.params); j++ {
+		switch p := f.params[j]; p {
+		case "&optional":
+			optional = true
+		case "&rest":
+			if j+1 < len(f.params) {
+				var rest interface{}
+				if i < len(args) {
+					rest = append([]interface{}{}, args[i:]...)
+				}
+				vars[f.params[j+1]] = rest
+				i = len(args)
+			}
+			j = len(f.params)
+		default:
+			if i < len(args) {
+				vars[p] = args[i]
+				i++
+			} else if optional {
+				vars[p] = nil
+			} else {
+				return nil, signal("wrong-number-of-arguments", f.name, len(args))
+			}
+		}
+	}
+	if i < len(args) {
+		return nil, signal("wrong-number-of-arguments", f.name, len(args))
+	}
+	return in.progn(f.body, &env{vars: vars, parent: f.env})
+}
+
+func makeLambda(name string, x []interface{}, e *env) *Lambda {
+	f := &Lambda{name: name, env: e}
+	if len(x) > 0 {
+		params, _ := x[0].([]interface{})
+		for _, p := range params {
+			if s, ok := p.(parser.Symbol); ok {
+				f.params = append(f.params, string(s))
+			}
+		}
+		f.body = x[1:]
+	}
+	return f
+}
+
+func (in *Interp) progn(body []interface{}, e *env) (interface{}, error) {
+	var ret interface{}
+	for _, x := range body {
+		var err error
+		if ret, err = in.eval(x, e); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// setVar sets the innermost binding, or the global variable.
+func (in *Interp) setVar(name string, v interface{}, e *env) {
+	if be, ok := e.lookup(name); ok {
+		be.vars[name] = v
+		return
+	}
+	in.globals[name] = v
+}
+
+// Error is an elisp signal, such as (error "message").
+type Error struct {
+	Symbol parser.Symbol
+	Data   []interface{}
+}
+
+func (e *Error) Error() string {
+	if len(e.Data) == 1 {
+		if msg, ok := e.Data[0].(string); ok && e.Symbol == "error" {
+			return msg
+		}
+	}
+	parts := []string{string(e.Symbol)}
+	for _, d := range e.Data {
+		parts = append(parts, Prin1ToString(d))
+	}
+	return strings.Join(parts, ": ")
+}
+
+func signal(sym string, data ...interface{}) *Error {
+	return &Error{Symbol: parser.Symbol(sym), Data: data}
+}
+
+// toSignal transforms the Go errors into signals for condition-case.
+func toSignal(err error) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	return signal("error", err.Error())
+}
+
+// throw is the non-local exit of throw, caught by catch.
+type throw struct {
+	tag   interface{}
+	value interface{}
+}
+
+func (t *throw) Error() string {
+	return "no-catch: " + Prin1ToString(t.tag)
+}
@@ -0,0 +1,182 @@
+package interp
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/kiwanami/go-elrpc"
+)
+
+func testEval(t *testing.T, in *Interp, src string, expected string) {
+	ret, err := in.EvalString(src)
+	if err != nil {
+		t.Errorf("Eval %s: %v", src, err)
+		return
+	}
+	if s := Prin1ToString(ret); s != expected {
+		t.Errorf("Eval %s:\n  expected: %s\n  returned: %s", src, expected, s)
+	}
+}
+
+func TestEval1(t *testing.T) {
+	in := New()
+	testEval(t, in, `(+ 1 2 (* 3 4))`, `15`)
+	testEval(t, in, `(/ 7 2.0)`, `3.5`)
+	testEval(t, in, `(list 'a "b" ?c 1.5 [1 2] '(x . y) :key nil t)`, `(a "b" 99 1.5 [1 2] (x . y) :key nil t)`)
+	testEval(t, in, `(let ((a 1) (b 2)) (let* ((a 10) (c (+ a b))) c))`, `12`)
+	testEval(t, in, `(defun fact (n) (if (<= n 1) 1 (* n (fact (1- n))))) (fact 10)`, `3628800`)
+	testEval(t, in, `(defun make-counter () (let ((n 0)) (lambda () (setq n (1+ n)))))
+(setq c (make-counter)) (funcall c) (funcall c)`, `2`)
+	testEval(t, in, `(mapcar (lambda (x) (* x x)) '(1 2 3))`, `(1 4 9)`)
+	testEval(t, in, `(cond ((null '(1)) 'a) ((consp '(1)) 'b))`, `b`)
+	testEval(t, in, `(let ((r nil)) (dolist (x '(1 2 3) r) (setq r (cons x r))))`, `(3 2 1)`)
+	testEval(t, in, `(let ((s 0)) (dotimes (i 5) (setq s (+ s i))) s)`, `10`)
+	testEval(t, in, `(let ((i 0)) (while (< i 3) (setq i (1+ i))) i)`, `3`)
+	testEval(t, in, `(let ((xs '(2 3))) `+"`"+`(1 ,@xs ,(length xs) . ,xs))`, `(1 2 3 2 2 3)`)
+	testEval(t, in, `(defmacro my-inc (v) `+"`"+`(setq ,v (1+ ,v))) (let ((x 1)) (my-inc x) x)`, `2`)
+	testEval(t, in, `(format "%s=%S %d%% %05.1f %c" 'a "b" 12 3.14159 ?x)`, `"a=\"b\" 12% 003.1 x"`)
+	testEval(t, in, `(concat "ab" (substring "hello" 1 -1) (upcase "x"))`, `"abellX"`)
+	testEval(t, in, `(assoc "b" '(("a" . 1) ("b" . 2)))`, `("b" . 2)`)
+	testEval(t, in, `(apply #'+ 1 '(2 3))`, `6`)
+	testEval(t, in, `(append '(1) '(2 3) nil)`, `(1 2 3)`)
+	testEval(t, in, `(defvar v1 1) (defvar v1 2) v1`, `1`)
+	testEval(t, in, `(catch 'done (dolist (x '(1 2 3)) (when (= x 2) (throw 'done x))))`, `2`)
+}
+
+func TestEvalErrors1(t *testing.T) {
+	in := New()
+	testEval(t, in, `(condition-case err (car 1) (wrong-type-argument (list 'caught (car err))))`, `(caught wrong-type-argument)`)
+	testEval(t, in, `(condition-case nil (car 1) ((void-function wrong-type-argument) 'multi))`, `multi`)
+	testEval(t, in, `(condition-case err (error "bad %d" 1) (error (cdr err)))`, `("bad 1")`)
+	testEval(t, in, `(let ((x 0)) (condition-case nil (unwind-protect (error "e") (setq x 1)) (error x)))`, `1`)
+
+	for _, src := range []string{`(undefined-fn)`, `undefined-var`, `(car 1)`, `(error "msg")`, `(/ 1 0)`, `(defun f () (f)) (f)`} {
+		if _, err := in.EvalString(src); err == nil {
+			t.Errorf("Expected an error: %s", src)
+		}
+	}
+	_, err := in.EvalString(`(error "Hello %s" "world")`)
+	if e, ok := err.(*Error); !ok || e.Symbol != "error" || e.Error() != "Hello world" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestEvalNumbers1(t *testing.T) {
+	in := New()
+	testEval(t, in, `(+ most-positive-fixnum 1)`, `9223372036854775808`)
+	testEval(t, in, `(- (- most-positive-fixnum) 2)`, `-9223372036854775809`)
+	testEval(t, in, `(* 4611686018427387904 4)`, `18446744073709551616`)
+	testEval(t, in, `(- (+ most-positive-fixnum 1) 1)`, `9223372036854775807`)
+	testEval(t, in, `(integerp (- (+ most-positive-fixnum 1) 1))`, `t`)
+	testEval(t, in, `(1+ most-positive-fixnum)`, `9223372036854775808`)
+	testEval(t, in, `(/ (* most-positive-fixnum 4) 2.0)`, `1.8446744073709552e+19`)
+	testEval(t, in, `(< most-positive-fixnum (1+ most-positive-fixnum))`, `t`)
+	testEval(t, in, `(max 3 1.0)`, `3`)
+	testEval(t, in, `(max 1 2.5)`, `2.5`)
+	testEval(t, in, `(min 3 1.0 2)`, `1.0`)
+	testEval(t, in, `(max 1 (1+ most-positive-fixnum))`, `9223372036854775808`)
+	testEval(t, in, `(list (eq 1.0 1.0) (eql 1.0 1.0) (eql 0.0 -0.0) (eq 1 1))`, `(nil t nil t)`)
+	testEval(t, in, `(let ((a (1+ most-positive-fixnum))) (list (eq a a) (eql a (1+ most-positive-fixnum))))`, `(t t)`)
+
+	for _, src := range []string{`(max)`, `(min)`} {
+		_, err := in.EvalString(src)
+		if e, ok := err.(*Error); !ok || e.Symbol != "wrong-number-of-arguments" {
+			t.Errorf("Unexpected error of %s: %v", src, err)
+		}
+	}
+}
+
+func TestEvalSequences1(t *testing.T) {
+	in := New()
+	testEval(t, in, `(sort '(3 1 2) #'<)`, `(1 2 3)`)
+	testEval(t, in, `(sort [3 1 2] #'>)`, `[3 2 1]`)
+	testEval(t, in, `(sort '((1 . a) (0 . b) (1 . c)) (lambda (x y) (< (car x) (car y))))`, `((0 . b) (1 . a) (1 . c))`)
+	testEval(t, in, `(sort nil #'<)`, `nil`)
+	testEval(t, in, `(nreverse (list 1 2 3))`, `(3 2 1)`)
+	testEval(t, in, `(nreverse [1 2 3])`, `[3 2 1]`)
+	testEval(t, in, `(make-string 3 ?x)`, `"xxx"`)
+	testEval(t, in, `(make-string 2 ?あ)`, `"ああ"`)
+	testEval(t, in, `(make-string 0 ?x)`, `""`)
+
+	testEval(t, in, `(string-match "b+" "abbc")`, `1`)
+	testEval(t, in, `(string-match "x" "abc")`, `nil`)
+	testEval(t, in, `(string-match "B" "abc")`, `1`)
+	testEval(t, in, `(progn (setq case-fold-search nil) (string-match "B" "abc"))`, `nil`)
+	testEval(t, in, `(setq case-fold-search t)`, `t`)
+	testEval(t, in, `(let ((s "key=value")) (string-match "\\([a-z]+\\)=\\(.*\\)" s) (list (match-string 1 s) (match-string 2 s) (match-beginning 2) (match-end 0)))`, `("key" "value" 4 9)`)
+	testEval(t, in, `(string-match "(a|b)" "x(a|b)")`, `1`)
+	testEval(t, in, `(string-match "a\\|c" "xc")`, `1`)
+	testEval(t, in, `(string-match "^b" "a\nb")`, `2`)
+	testEval(t, in, `(string-match "[]a]+" "x]a")`, `1`)
+	testEval(t, in, `(string-match "[[:digit:]]\\{2,\\}" "a1b23")`, `3`)
+	testEval(t, in, `(string-match "か" "あいか" 1)`, `2`)
+	testEval(t, in, `(progn (string-match "a\\(x\\)?" "a") (match-beginning 1))`, `nil`)
+
+	for _, c := range []struct{ src, sym string }{
+		{`(make-string -1 ?x)`, "wrong-type-argument"},
+		{`(sort '(1 "a") #'<)`, "wrong-type-argument"},
+		{`(string-match "\\(a" "a")`, "invalid-regexp"},
+		{`(string-match "\\(a\\)\\1" "aa")`, "invalid-regexp"},
+	} {
+		_, err := in.EvalString(c.src)
+		if e, ok := err.(*Error); !ok || string(e.Symbol) != c.sym {
+			t.Errorf("Unexpected error of %s: %v", c.src, err)
+		}
+	}
+}
+
+// startCalc starts examples/calc as epc:start-epc does.
+func startCalc(t *testing.T) EPCStarter {
+	return func(cmd []string) (elrpc.Service, error) {
+		proc := exec.Command("go", "run", "../examples/calc/calc.go")
+		proc.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		stdout, err := proc.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := proc.Start(); err != nil {
+			return nil, err
+		}
+		t.Cleanup(func() {
+			_ = syscall.Kill(-proc.Process.Pid, syscall.SIGINT)
+			_ = proc.Wait()
+		})
+		lineBuf := bufio.NewScanner(stdout)
+		lineBuf.Scan()
+		port, err := strconv.Atoi(lineBuf.Text())
+		if err != nil {
+			return nil, err
+		}
+		return elrpc.StartClient(port, nil)
+	}
+}
+
+func TestCalcClient(t *testing.T) {
+	in := New()
+	var out bytes.Buffer
+	in.Output = &out
+	in.BindEPC(startCalc(t))
+	if err := in.LoadFile("../examples/calc/client.el"); err != nil {
+		t.Fatal(err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	expected := []string{`Return : 50`, `Return : "AABB"`, `Return : 55`}
+	if len(lines) != 4 || strings.Join(lines[:3], "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Unexpected output:\n%s", out.String())
+	}
+	// the order of the methods is not defined
+	for _, m := range []string{
+		`(addi "int, int" "add integers")`,
+		`(adds "string, string" "concat strings")`,
+		`(reducei "(list []int, op string) -> int" "calculate and reduce list elements to a result value")`,
+	} {
+		if !strings.Contains(lines[3], m) {
+			t.Errorf("Method not found: %s in %s", m, lines[3])
+		}
+	}
+}
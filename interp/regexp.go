@@ -0,0 +1,148 @@
+package interp
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileRegexp translates the Emacs regexp into the Go one, and
+// compiles it. The back references and the syntax classes other than
+// the whitespace are not supported.
+func compileRegexp(pat string, fold bool) (*regexp.Regexp, error) {
+	var buf strings.Builder
+	// ^ and $ match at the lines, as Emacs
+	buf.WriteString("(?m")
+	if fold {
+		buf.WriteString("i")
+	}
+	buf.WriteString(")")
+	rs := []rune(pat)
+	// the operators at the beginning of the pattern or a group are literal
+	start := true
+	for i := 0; i < len(rs); i++ {
+		c := rs[i]
+		atStart := start
+		start = false
+		switch c {
+		case '\\':
+			i++
+			if i == len(rs) {
+				return nil, signal("invalid-regexp", "Trailing backslash")
+			}
+			switch c := rs[i]; c {
+			case '(':
+				if i+2 < len(rs) && rs[i+1] == '?' && rs[i+2] == ':' {
+					buf.WriteString("(?:")
+					i += 2
+				} else {
+					buf.WriteString("(")
+				}
+				start = true
+			case '|':
+				buf.WriteString("|")
+				start = true
+			case ')', '}':
+				buf.WriteRune(c)
+			case '{':
+				buf.WriteString("{")
+				if i+1 < len(rs) && rs[i+1] == ',' {
+					buf.WriteString("0")
+				}
+			case 'w':
+				buf.WriteString(`[\pL\pN]`)
+			case 'W':
+				buf.WriteString(`[^\pL\pN]`)
+			case 'b', 'B':
+				buf.WriteString(`\` + string(c))
+			case '<', '>':
+				buf.WriteString(`\b`)
+			case '_':
+				if i+1 == len(rs) || (rs[i+1] != '<' && rs[i+1] != '>') {
+					return nil, signal("invalid-regexp", "Invalid symbol boundary")
+				}
+				i++
+				buf.WriteString(`\b`)
+			case '`':
+				buf.WriteString(`\A`)
+			case '\'':
+				buf.WriteString(`\z`)
+			case 's', 'S':
+				if i+1 == len(rs) || (rs[i+1] != '-' && rs[i+1] != ' ') {
+					return nil, signal("invalid-regexp", "Unsupported syntax class")
+				}
+				i++
+				buf.WriteString(`\` + string(c))
+			default:
+				if '1' <= c && c <= '9' {
+					return nil, signal("invalid-regexp", "Unsupported back reference")
+				}
+				buf.WriteString(regexp.QuoteMeta(string(c)))
+			}
+		case '(', ')', '|', '{', '}', ']':
+			buf.WriteString(`\` + string(c))
+		case '*', '+', '?':
+			if atStart {
+				buf.WriteString(`\` + string(c))
+			} else {
+				buf.WriteRune(c)
+			}
+		case '^':
+			if atStart {
+				buf.WriteString("^")
+				start = true
+			} else {
+				buf.WriteString(`\^`)
+			}
+		case '$':
+			if i+1 == len(rs) || (rs[i+1] == '\\' && i+2 < len(rs) && (rs[i+2] == ')' || rs[i+2] == '|')) {
+				buf.WriteString("$")
+			} else {
+				buf.WriteString(`\$`)
+			}
+		case '[':
+			n, err := translateBracket(&buf, rs[i:])
+			if err != nil {
+				return nil, err
+			}
+			i += n - 1
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	re, err := regexp.Compile(buf.String())
+	if err != nil {
+		return nil, signal("invalid-regexp", err.Error())
+	}
+	return re, nil
+}
+
+// translateBracket writes the character set at the beginning of rs, and
+// returns its length. The backslashes are literal in the set of Emacs.
+func translateBracket(buf *strings.Builder, rs []rune) (int, error) {
+	buf.WriteString("[")
+	i := 1
+	if i < len(rs) && rs[i] == '^' {
+		buf.WriteString("^")
+		i++
+	}
+	for first := true; i < len(rs); i, first = i+1, false {
+		switch c := rs[i]; {
+		case c == ']' && !first:
+			buf.WriteString("]")
+			return i + 1, nil
+		case c == '[' && i+1 < len(rs) && rs[i+1] == ':':
+			end := strings.Index(string(rs[i:]), ":]")
+			if end < 0 {
+				return 0, signal("invalid-regexp", "Unmatched [ or [^")
+			}
+			class := string(rs[i:])[:end+2]
+			buf.WriteString(class)
+			i += len([]rune(class)) - 1
+		case c == '\\' || c == '[' || c == ']' || c == '^':
+			buf.WriteString(`\` + string(c))
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return 0, signal("invalid-regexp", "Unmatched [ or [^")
+}
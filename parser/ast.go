@@ -2,12 +2,64 @@ package parser
 
 import (
 	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type SExp interface {
-	ToSExpString() string // express in S-exp string
-	ToValue() interface{} // transform content of this AST into Go object
+	ToSExpString() string                    // express in S-exp string
+	ToValue() interface{}                    // transform content of this AST into Go object
+	ToValueWith(o *ValueOptions) interface{} // transform with the options
+	Span() Span                              // source range of this AST (zero for the constructed one)
+}
+
+// spanned holds the source range of an AST node, and the concrete syntax
+// when parsed by ParseConcrete.
+type spanned struct {
+	span Span
+	cst  *concrete
+}
+
+func (s *spanned) Span() Span {
+	return s.span
+}
+
+func (s *spanned) setSpan(sp Span) {
+	s.span = sp
+}
+
+// ValueOptions controls the transformation from AST into Go objects.
+// The nil options mean the default behavior.
+type ValueOptions struct {
+	CharAsRune      bool // decode a character into rune instead of int
+	PreserveSymbols bool // decode a symbol into Symbol or Keyword instead of string
+	PreserveCons    bool // decode a dotted pair into Cons instead of a slice
+
+	labels *labelValues // the values of the labels in the current decode
+}
+
+// labelValues memoizes the values of the labels during a decode, so that
+// the references share the value instead of decoding the target again.
+type labelValues struct {
+	values map[*SExpLabel]interface{}
+}
+
+// decoding returns the options with the label values of the decode. A
+// node with the children starts a new decode unless it is in one.
+func (o *ValueOptions) decoding() *ValueOptions {
+	if o != nil && o.labels != nil {
+		return o
+	}
+	d := &ValueOptions{}
+	if o != nil {
+		*d = *o
+	}
+	d.labels = &labelValues{}
+	return d
 }
 
 type SExpAtom struct{}
@@ -15,6 +67,7 @@ type SExpAtom struct{}
 func (s *SExpAtom) ToSExpString() string { return "--ATOM--" }
 
 type SExpNil struct {
+	spanned
 	*SExpAtom
 }
 
@@ -22,10 +75,15 @@ func (s *SExpNil) ToSExpString() string {
 	return "nil"
 }
 func (s *SExpNil) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpNil) ToValueWith(o *ValueOptions) interface{} {
 	return nil
 }
 
 type SExpChar struct {
+	spanned
 	*SExpAtom
 	literal string
 }
@@ -38,11 +96,27 @@ func (s *SExpChar) ToSExpString() string {
 		return "?" + s.literal
 	}
 }
+
+// ToValue returns the character code as int.
 func (s *SExpChar) ToValue() interface{} {
-	return s.literal
+	return s.ToValueWith(nil)
+}
+
+// ToValueWith returns rune instead of int with the CharAsRune option.
+func (s *SExpChar) ToValueWith(o *ValueOptions) interface{} {
+	c, err := CharCode(s.literal)
+	if err != nil {
+		r, _ := utf8.DecodeRuneInString(s.literal)
+		c = int(r)
+	}
+	if o != nil && o.CharAsRune {
+		return rune(c)
+	}
+	return c
 }
 
 type SExpString struct {
+	spanned
 	*SExpAtom
 	literal string
 }
@@ -51,21 +125,38 @@ func (s *SExpString) ToSExpString() string {
 	return StringLiteral(s.literal)
 }
 func (s *SExpString) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpString) ToValueWith(o *ValueOptions) interface{} {
 	return s.literal
 }
 
 type SExpSymbol struct {
+	spanned
 	*SExpAtom
 	literal string
 }
 
 func (s *SExpSymbol) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+// ToValueWith returns Symbol or Keyword instead of string with the
+// PreserveSymbols option.
+func (s *SExpSymbol) ToValueWith(o *ValueOptions) interface{} {
 	if s.literal == "t" {
 		return true
 	}
 	if s.literal == "nil" {
 		return nil
 	}
+	if o != nil && o.PreserveSymbols {
+		if len(s.literal) > 1 && s.literal[0] == ':' {
+			return Keyword(s.literal[1:])
+		}
+		return Symbol(s.literal)
+	}
 	return s.literal
 }
 
@@ -74,6 +165,7 @@ func (s *SExpSymbol) ToSExpString() string {
 }
 
 type SExpInt struct {
+	spanned
 	*SExpAtom
 	literal string
 }
@@ -83,11 +175,21 @@ func (s *SExpInt) ToSExpString() string {
 }
 
 func (s *SExpInt) ToValue() interface{} {
-	i, _ := strconv.Atoi(s.literal)
-	return i
+	return s.ToValueWith(nil)
+}
+
+// ToValueWith returns int, or *big.Int for a bignum beyond the range of int.
+func (s *SExpInt) ToValueWith(o *ValueOptions) interface{} {
+	return parseIntLiteral(s.literal)
+}
+
+func (s *SExpInt) isBig() bool {
+	_, ok := s.ToValue().(*big.Int)
+	return ok
 }
 
 type SExpFloat struct {
+	spanned
 	*SExpAtom
 	literal string
 }
@@ -97,17 +199,29 @@ func (s *SExpFloat) ToSExpString() string {
 }
 
 func (s *SExpFloat) ToValue() interface{} {
-	f, _ := strconv.ParseFloat(s.literal, 64)
-	return f
+	return s.ToValueWith(nil)
 }
 
-func inferArrayType(lst []SExp) string {
+func (s *SExpFloat) ToValueWith(o *ValueOptions) interface{} {
+	return parseFloatLiteral(s.literal)
+}
+
+func inferArrayType(lst []SExp, o *ValueOptions) string {
 	if len(lst) == 0 {
 		return "interface"
 	}
+	if o != nil && o.CharAsRune {
+		for _, v := range lst {
+			if _, ok := v.(*SExpChar); ok {
+				return "interface"
+			}
+		}
+	}
 	var ty string
 	switch lst[0].(type) {
-	case *SExpChar, *SExpString, *SExpSymbol:
+	case *SExpChar:
+		ty = "int"
+	case *SExpString, *SExpSymbol:
 		ty = "string"
 	case *SExpFloat:
 		ty = "float"
@@ -117,9 +231,9 @@ func inferArrayType(lst []SExp) string {
 		return "interface"
 	}
 	for _, v := range lst {
-		switch v.(type) {
+		switch v := v.(type) {
 		case *SExpChar:
-			if ty != "string" {
+			if ty != "float" && ty != "int" {
 				return "interface"
 			}
 		case *SExpFloat:
@@ -132,6 +246,9 @@ func inferArrayType(lst []SExp) string {
 			if ty != "float" && ty != "int" {
 				return "interface"
 			}
+			if v.isBig() {
+				return "interface"
+			}
 		default:
 			return "interface"
 		}
@@ -139,40 +256,33 @@ func inferArrayType(lst []SExp) string {
 	return ty
 }
 
-func typedSlice(lst []SExp) interface{} {
+func typedSlice(lst []SExp, o *ValueOptions) interface{} {
 	len := len(lst)
-	typ := inferArrayType(lst)
+	typ := inferArrayType(lst, o)
 	// pp.Println(lst)
 	// fmt.Println("typedSlice: " + typ)
 	switch typ {
 	case "int":
 		ret := make([]int, len)
 		for i := 0; i < len; i++ {
-			s := lst[i].(*SExpInt)
-			v, _ := strconv.Atoi(s.literal)
-			ret[i] = v
+			ret[i] = lst[i].ToValueWith(o).(int)
 		}
 		return ret
 	case "float":
 		ret := make([]float64, len)
 		for i := 0; i < len; i++ {
-			var lit string
-			switch lst[i].(type) {
+			switch s := lst[i].(type) {
 			case *SExpFloat:
-				s := lst[i].(*SExpFloat)
-				lit = s.literal
-			case *SExpInt:
-				s := lst[i].(*SExpInt)
-				lit = s.literal
+				ret[i] = parseFloatLiteral(s.literal)
+			default:
+				ret[i] = float64(s.ToValueWith(o).(int))
 			}
-			v, _ := strconv.ParseFloat(lit, 64)
-			ret[i] = v
 		}
 		return ret
 	case "string":
 		ret := make([]string, len)
 		for i := 0; i < len; i++ {
-			s := lst[i].ToValue()
+			s := lst[i].ToValueWith(o)
 			v, _ := s.(string)
 			ret[i] = v
 		}
@@ -181,7 +291,7 @@ func typedSlice(lst []SExp) interface{} {
 	}
 	ret := make([]interface{}, len)
 	for i, e := range lst {
-		ret[i] = e.ToValue()
+		ret[i] = e.ToValueWith(o)
 	}
 	return ret
 }
@@ -191,6 +301,7 @@ type AbstSExpCons struct{}
 func (s *AbstSExpCons) ToSExpString() string { return "--CONS--" }
 
 type SExpCons struct {
+	spanned
 	*AbstSExpCons
 	car, cdr SExp
 }
@@ -199,10 +310,49 @@ func (s *SExpCons) ToSExpString() string {
 	return "(" + s.car.ToSExpString() + " . " + s.cdr.ToSExpString() + ")"
 }
 func (s *SExpCons) ToValue() interface{} {
-	return typedSlice([]SExp{s.car, s.cdr})
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpCons) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	if o != nil && o.PreserveCons {
+		return consValue([]SExp{s.car}, s.cdr, o)
+	}
+	return typedSlice([]SExp{s.car, s.cdr}, o)
+}
+
+// consValue transforms a dotted list into nested Cons values.
+// The result is a slice when the last cdr is a list or nil, because
+// (a . (b c)) is the same list as (a b c).
+func consValue(elements []SExp, last SExp, o *ValueOptions) interface{} {
+	for {
+		// clip the capacity not to overwrite the elements of the nodes
+		elements = elements[:len(elements):len(elements)]
+		switch l := last.(type) {
+		case *SExpNil:
+			return typedSlice(elements, o)
+		case *SExpList:
+			return typedSlice(append(elements, l.elements...), o)
+		case *SExpCons:
+			elements = append(elements, l.car)
+			last = l.cdr
+			continue
+		case *SExpListDot:
+			elements = append(elements, l.elements...)
+			last = l.last
+			continue
+		}
+		break
+	}
+	ret := last.ToValueWith(o)
+	for i := len(elements) - 1; i >= 0; i-- {
+		ret = Cons{Car: elements[i].ToValueWith(o), Cdr: ret}
+	}
+	return ret
 }
 
 type SExpList struct {
+	spanned
 	*AbstSExpCons
 	elements []SExp
 }
@@ -224,10 +374,16 @@ func (s *SExpList) ToSExpString() string {
 }
 
 func (s *SExpList) ToValue() interface{} {
-	return typedSlice(s.elements)
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpList) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	return typedSlice(s.elements, o)
 }
 
 type SExpListDot struct {
+	spanned
 	*AbstSExpCons
 	elements []SExp
 	last     SExp
@@ -251,11 +407,20 @@ func (s *SExpListDot) ToSExpString() string {
 	return buf.String()
 }
 func (s *SExpListDot) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpListDot) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	if o != nil && o.PreserveCons {
+		return consValue(s.elements, s.last, o)
+	}
 	aa := append(s.elements, s.last)
-	return typedSlice(aa)
+	return typedSlice(aa, o)
 }
 
 type SExpVector struct {
+	spanned
 	*AbstSExpCons
 	elements []SExp
 }
@@ -276,10 +441,322 @@ func (s *SExpVector) ToSExpString() string {
 	return buf.String()
 }
 func (s *SExpVector) ToValue() interface{} {
-	return typedSlice(s.elements)
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpVector) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	return typedSlice(s.elements, o)
+}
+
+// SExpHashTable is a hash table, #s(hash-table test equal data (k1 v1)).
+type SExpHashTable struct {
+	spanned
+	props []SExp // properties except data, such as test and size
+	data  []SExp // keys and values
+}
+
+func (s *SExpHashTable) ToSExpString() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("#s(hash-table")
+	for _, e := range s.props {
+		buf.WriteByte(' ')
+		buf.WriteString(e.ToSExpString())
+	}
+	buf.WriteString(" data (")
+	for i, e := range s.data {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(e.ToSExpString())
+	}
+	buf.WriteString("))")
+	return buf.String()
+}
+func (s *SExpHashTable) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpHashTable) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	var ret HashTable
+	for i := 0; i+1 < len(s.props); i += 2 {
+		if sym, ok := s.props[i].(*SExpSymbol); ok && sym.literal == "test" {
+			ret.Test = s.props[i+1].ToSExpString()
+		}
+	}
+	entries := make([]Cons, 0, len(s.data)/2)
+	hashable := true
+	for i := 0; i+1 < len(s.data); i += 2 {
+		k := s.data[i].ToValueWith(o)
+		hashable = hashable && isHashable(k)
+		entries = append(entries, Cons{Car: k, Cdr: s.data[i+1].ToValueWith(o)})
+	}
+	if !hashable {
+		ret.Alist = entries
+		return ret
+	}
+	ret.Data = make(map[interface{}]interface{}, len(entries))
+	for _, e := range entries {
+		ret.Data[e.Car] = e.Cdr
+	}
+	return ret
+}
+
+// isHashable reports whether v can be a key of Go maps.
+func isHashable(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case Cons:
+		return isHashable(v.Car) && isHashable(v.Cdr)
+	case *big.Int:
+		return false // compared by the pointer
+	}
+	return reflect.TypeOf(v).Comparable()
+}
+
+// SExpRecord is a record, #s(type slot1 slot2).
+type SExpRecord struct {
+	spanned
+	elements []SExp // type and slots
+}
+
+func (s *SExpRecord) ToSExpString() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("#s(")
+	for i, e := range s.elements {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(e.ToSExpString())
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+func (s *SExpRecord) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpRecord) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	ret := Record{Slots: make([]interface{}, len(s.elements)-1)}
+	if sym, ok := s.elements[0].(*SExpSymbol); ok {
+		ret.Type = sym.literal
+	}
+	for i, e := range s.elements[1:] {
+		ret.Slots[i] = e.ToValueWith(o)
+	}
+	return ret
+}
+
+// SExpBoolVector is a bool-vector, #&N"...".
+type SExpBoolVector struct {
+	spanned
+	bits []bool
+}
+
+func (s *SExpBoolVector) ToSExpString() string {
+	return BoolVectorLiteral(s.bits)
+}
+func (s *SExpBoolVector) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpBoolVector) ToValueWith(o *ValueOptions) interface{} {
+	return BoolVector(append([]bool{}, s.bits...))
+}
+
+// SExpPropertizedString is a string with text properties,
+// #("text" start end plist ...).
+type SExpPropertizedString struct {
+	spanned
+	text  string
+	props []SExp // triples of start, end and plist
+}
+
+func (s *SExpPropertizedString) ToSExpString() string {
+	buf := bytes.Buffer{}
+	buf.WriteString("#(")
+	buf.WriteString(StringLiteral(s.text))
+	for _, e := range s.props {
+		buf.WriteByte(' ')
+		buf.WriteString(e.ToSExpString())
+	}
+	buf.WriteByte(')')
+	return buf.String()
+}
+func (s *SExpPropertizedString) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpPropertizedString) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	ret := PropertizedString{Text: s.text, Intervals: make([]TextInterval, 0, len(s.props)/3)}
+	for i := 0; i+2 < len(s.props); i += 3 {
+		start, _ := s.props[i].ToValueWith(o).(int)
+		end, _ := s.props[i+1].ToValueWith(o).(int)
+		ti := TextInterval{Start: start, End: end, Props: make(map[string]interface{})}
+		if pl, ok := s.props[i+2].(*SExpList); ok {
+			for j := 0; j+1 < len(pl.elements); j += 2 {
+				key := pl.elements[j].ToSExpString()
+				if sym, ok := pl.elements[j].(*SExpSymbol); ok {
+					key = sym.literal
+				}
+				ti.Props[key] = pl.elements[j+1].ToValueWith(o)
+			}
+		}
+		ret.Intervals = append(ret.Intervals, ti)
+	}
+	return ret
+}
+
+// SExpLabel is a labelled object of the print-circle syntax, #1=(a b).
+type SExpLabel struct {
+	spanned
+	label int
+	sexp  SExp
+}
+
+func (s *SExpLabel) ToSExpString() string {
+	return "#" + strconv.Itoa(s.label) + "=" + s.sexp.ToSExpString()
+}
+func (s *SExpLabel) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpLabel) ToValueWith(o *ValueOptions) interface{} {
+	o = o.decoding()
+	if v, ok := o.labels.values[s]; ok {
+		return v
+	}
+	v := s.sexp.ToValueWith(o)
+	if o.labels.values == nil {
+		o.labels.values = make(map[*SExpLabel]interface{})
+	}
+	o.labels.values[s] = v
+	return v
+}
+
+// SExpLabelRef is a reference to a labelled object, #1#. The parser
+// links it to the SExpLabel, so that the AST shares the object.
+type SExpLabelRef struct {
+	spanned
+	label  int
+	target *SExpLabel
+	cyclic bool // the reference is inside of the target
+}
+
+func (s *SExpLabelRef) ToSExpString() string {
+	return "#" + strconv.Itoa(s.label) + "#"
+}
+func (s *SExpLabelRef) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+// ToValueWith returns the value of the target, which is shared with the
+// label and the other references in the same decode. A cyclic reference
+// is transformed into nil, because the Go values can not be cyclic. Use
+// CheckCycles to report them.
+func (s *SExpLabelRef) ToValueWith(o *ValueOptions) interface{} {
+	if s.target == nil || s.cyclic {
+		return nil
+	}
+	return s.target.ToValueWith(o.decoding())
+}
+
+func labelNumber(lit string) int {
+	n, _ := strconv.Atoi(strings.Trim(lit, "#="))
+	return n
+}
+
+// children returns the child nodes of the AST node. The target of a
+// label reference is not included.
+func children(s SExp) []SExp {
+	switch s := s.(type) {
+	case *SExpCons:
+		return []SExp{s.car, s.cdr}
+	case *SExpList:
+		return s.elements
+	case *SExpListDot:
+		return append(s.elements[:len(s.elements):len(s.elements)], s.last)
+	case *SExpVector:
+		return s.elements
+	case *SExpHashTable:
+		return append(s.props[:len(s.props):len(s.props)], s.data...)
+	case *SExpRecord:
+		return s.elements
+	case *SExpPropertizedString:
+		return s.props
+	case *SExpLabel:
+		return []SExp{s.sexp}
+	case *SExpQuoted:
+		return []SExp{s.sexp}
+	case *SExpQuasiQuoted:
+		return []SExp{s.sexp}
+	case *SExpUnquote:
+		return []SExp{s.sexp}
+	}
+	return nil
+}
+
+// resolveLabels links the label references to the labels defined before
+// them, and marks the references inside of their own labels as cyclic.
+// The undefined references are reported to the function.
+func resolveLabels(sexps []SExp, report func(s SExp, msg string)) {
+	defined := make(map[int]*SExpLabel)
+	onPath := make(map[*SExpLabel]bool)
+	var walk func(s SExp)
+	walk = func(s SExp) {
+		switch s := s.(type) {
+		case *SExpLabel:
+			defined[s.label] = s
+			onPath[s] = true
+			defer delete(onPath, s)
+		case *SExpLabelRef:
+			s.target = defined[s.label]
+			if s.target == nil {
+				report(s, "Undefined label: "+s.ToSExpString())
+				return
+			}
+			s.cyclic = onPath[s.target]
+			return
+		}
+		for _, c := range children(s) {
+			walk(c)
+		}
+	}
+	for _, s := range sexps {
+		walk(s)
+	}
+}
+
+// CheckCycles returns an error when the S-expressions have a cyclic
+// structure, such as #1=(a . #1#), which can not be transformed into Go
+// values.
+func CheckCycles(sexps []SExp) error {
+	var walk func(s SExp) error
+	walk = func(s SExp) error {
+		if ref, ok := s.(*SExpLabelRef); ok && ref.cyclic {
+			return fmt.Errorf("cyclic structure via %s", ref.ToSExpString())
+		}
+		for _, c := range children(s) {
+			if err := walk(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, s := range sexps {
+		if err := walk(s); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type SExpQuoted struct {
+	spanned
 	sexp     SExp
 	function bool
 }
@@ -292,10 +769,15 @@ func (s *SExpQuoted) ToSExpString() string {
 	return ret + "'" + s.sexp.ToSExpString()
 }
 func (s *SExpQuoted) ToValue() interface{} {
-	return s.sexp.ToValue()
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpQuoted) ToValueWith(o *ValueOptions) interface{} {
+	return s.sexp.ToValueWith(o)
 }
 
 type SExpQuasiQuoted struct {
+	spanned
 	sexp SExp
 }
 
@@ -303,10 +785,15 @@ func (s *SExpQuasiQuoted) ToSExpString() string {
 	return "`" + s.sexp.ToSExpString()
 }
 func (s *SExpQuasiQuoted) ToValue() interface{} {
-	return s.sexp.ToValue()
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpQuasiQuoted) ToValueWith(o *ValueOptions) interface{} {
+	return s.sexp.ToValueWith(o)
 }
 
 type SExpUnquote struct {
+	spanned
 	sexp   SExp
 	splice bool
 }
@@ -319,10 +806,15 @@ func (s *SExpUnquote) ToSExpString() string {
 	return ret + s.sexp.ToSExpString()
 }
 func (s *SExpUnquote) ToValue() interface{} {
-	return s.sexp.ToValue()
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpUnquote) ToValueWith(o *ValueOptions) interface{} {
+	return s.sexp.ToValueWith(o)
 }
 
 type SExpWrapper struct {
+	spanned
 	buf []byte
 }
 
@@ -330,11 +822,15 @@ func (s *SExpWrapper) ToSExpString() string {
 	return string(s.buf)
 }
 func (s *SExpWrapper) ToValue() interface{} {
+	return s.ToValueWith(nil)
+}
+
+func (s *SExpWrapper) ToValueWith(o *ValueOptions) interface{} {
 	panic("BUG")
 }
 
 func AstWrapper(v []byte) *SExpWrapper {
-	return &SExpWrapper{v}
+	return &SExpWrapper{buf: v}
 }
 
 /// AST utilities
@@ -343,6 +839,11 @@ func AstInt(v string) *SExpInt {
 	return &SExpInt{literal: v}
 }
 
+// AstIntRadix makes an integer in the radix notation, such as #xff for colors and bitmasks.
+func AstIntRadix(v int64, radix int) *SExpInt {
+	return &SExpInt{literal: IntLiteral(v, radix)}
+}
+
 func AstFloat(v string) *SExpFloat {
 	return &SExpFloat{literal: v}
 }
@@ -358,6 +859,11 @@ func AstChar(v string) *SExpChar {
 	return &SExpChar{literal: v}
 }
 
+// AstCharCode makes a character from the code, which may have modifier bits.
+func AstCharCode(c int) *SExpChar {
+	return &SExpChar{literal: CharLiteral(c)[1:]}
+}
+
 func AstString(v string) *SExpString {
 	return &SExpString{literal: v}
 }
@@ -386,6 +892,34 @@ func AstDotlist(vs []SExp, v2 SExp) *SExpListDot {
 	return &SExpListDot{elements: vs, last: v2}
 }
 
+func AstHashTable(test string, data ...SExp) *SExpHashTable {
+	ret := &SExpHashTable{data: data}
+	if test != "" {
+		ret.props = []SExp{AstSymbol("test"), AstSymbol(test)}
+	}
+	return ret
+}
+
+func AstRecordv(typ string, slots ...SExp) *SExpRecord {
+	return &SExpRecord{elements: append([]SExp{AstSymbol(typ)}, slots...)}
+}
+
+func AstBoolVector(bits []bool) *SExpBoolVector {
+	return &SExpBoolVector{bits: bits}
+}
+
+func AstPropertizedString(text string, props ...SExp) *SExpPropertizedString {
+	return &SExpPropertizedString{text: text, props: props}
+}
+
+func AstLabel(label int, v SExp) *SExpLabel {
+	return &SExpLabel{label: label, sexp: v}
+}
+
+func AstLabelRef(label int) *SExpLabelRef {
+	return &SExpLabelRef{label: label}
+}
+
 func AstQ(v SExp) *SExpQuoted {
 	return &SExpQuoted{sexp: v}
 }
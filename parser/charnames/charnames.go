@@ -0,0 +1,109 @@
+// Package charnames resolves the Unicode character names, such as
+// "LATIN SMALL LETTER E WITH ACUTE", with the table of golang.org/x/text.
+// The parser accepts the names in \N{...} after the resolver is set:
+//
+//	parser.SetCharNameResolver(charnames.Lookup)
+package charnames
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/unicode/runenames"
+)
+
+// charNames indexes the characters named individually. The names made
+// of the code points, such as the CJK ideographs, are not indexed.
+var charNames struct {
+	once  sync.Once
+	table map[string]rune
+}
+
+// Lookup returns the character for a Unicode name, such as
+// "LATIN SMALL LETTER E WITH ACUTE". The name is case-insensitive.
+func Lookup(name string) (rune, bool) {
+	name = strings.ToUpper(strings.Join(strings.Fields(name), " "))
+	if r, ok := codePointName(name); ok {
+		return r, true
+	}
+	if r, ok := hangulSyllable(name); ok {
+		return r, true
+	}
+	charNames.once.Do(func() {
+		charNames.table = make(map[string]rune)
+		// the assigned characters, not all the code points
+		for _, t := range []*unicode.RangeTable{unicode.L, unicode.M, unicode.N, unicode.P, unicode.S, unicode.Z, unicode.Cc, unicode.Cf} {
+			for _, rg := range t.R16 {
+				indexCharNames(rune(rg.Lo), rune(rg.Hi), rune(rg.Stride))
+			}
+			for _, rg := range t.R32 {
+				indexCharNames(rune(rg.Lo), rune(rg.Hi), rune(rg.Stride))
+			}
+		}
+	})
+	r, ok := charNames.table[name]
+	return r, ok
+}
+
+func indexCharNames(lo, hi, stride rune) {
+	for r := lo; r <= hi; r += stride {
+		if n := runenames.Name(r); n != "" && !strings.HasPrefix(n, "<") {
+			charNames.table[n] = r
+		}
+	}
+}
+
+// codePointName returns the character of the name ending with the code
+// point, such as "CJK UNIFIED IDEOGRAPH-4E00".
+func codePointName(name string) (rune, bool) {
+	i := strings.LastIndexByte(name, '-')
+	if i < 0 || len(name)-i-1 < 4 {
+		return 0, false
+	}
+	c, err := strconv.ParseUint(name[i+1:], 16, 32)
+	if err != nil || c > unicode.MaxRune {
+		return 0, false
+	}
+	r := rune(c)
+	switch n := runenames.Name(r); {
+	case n == name:
+		return r, true
+	case name[:i] == "CJK UNIFIED IDEOGRAPH" && strings.HasPrefix(n, "<CJK Ideograph"),
+		name[:i] == "TANGUT IDEOGRAPH" && n == "<Tangut Ideograph>":
+		return r, true
+	}
+	return 0, false
+}
+
+// The short names of the jamos in the names of the Hangul syllables
+var (
+	jamoL = []string{"G", "GG", "N", "D", "DD", "R", "M", "B", "BB", "S", "SS", "", "J", "JJ", "C", "K", "T", "P", "H"}
+	jamoV = []string{"A", "AE", "YA", "YAE", "EO", "E", "YEO", "YE", "O", "WA", "WAE", "OE", "YO", "U", "WEO", "WE", "WI", "YU", "EU", "YI", "I"}
+	jamoT = []string{"", "G", "GG", "GS", "N", "NJ", "NH", "D", "L", "LG", "LM", "LB", "LS", "LT", "LP", "LH", "M", "B", "BS", "S", "SS", "NG", "J", "C", "K", "T", "P", "H"}
+)
+
+// hangulSyllable returns the Hangul syllable of the name, such as
+// "HANGUL SYLLABLE GAG", composed of the jamos.
+func hangulSyllable(name string) (rune, bool) {
+	name, ok := strings.CutPrefix(name, "HANGUL SYLLABLE ")
+	if !ok {
+		return 0, false
+	}
+	for l, ln := range jamoL {
+		rest, ok := strings.CutPrefix(name, ln)
+		if !ok {
+			continue
+		}
+		for v, vn := range jamoV {
+			if tn, ok := strings.CutPrefix(rest, vn); ok {
+				if t := slices.Index(jamoT, tn); t >= 0 {
+					return rune(0xAC00 + (l*len(jamoV)+v)*len(jamoT) + t), true
+				}
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,54 @@
+package charnames
+
+import (
+	"testing"
+	"unicode"
+
+	"github.com/kiwanami/go-elrpc/parser"
+	"golang.org/x/text/unicode/runenames"
+)
+
+func TestLookup(t *testing.T) {
+	data := map[string]rune{
+		"LATIN CAPITAL LETTER A":           'A',
+		"hyphen-minus":                     '-',
+		"GRINNING FACE":                    0x1f600,
+		"CJK UNIFIED IDEOGRAPH-4E00":       0x4e00,
+		"CJK UNIFIED IDEOGRAPH-20000":      0x20000,
+		"CJK COMPATIBILITY IDEOGRAPH-F900": 0xf900,
+		"HANGUL SYLLABLE GA":               0xac00,
+		"HANGUL SYLLABLE HIH":              0xd7a3,
+	}
+	for name, exp := range data {
+		if r, ok := Lookup(name); !ok || r != exp {
+			t.Errorf("char name [%s]: expected %U but %U (%v)", name, exp, r, ok)
+		}
+	}
+	for _, name := range []string{"NO SUCH NAME", "CJK UNIFIED IDEOGRAPH-0041", "HANGUL SYLLABLE X", "<CJK Ideograph>"} {
+		if r, ok := Lookup(name); ok {
+			t.Errorf("char name [%s]: found %U", name, r)
+		}
+	}
+	// the names of all the characters
+	for r := rune(0); r <= unicode.MaxRune; r++ {
+		name := runenames.Name(r)
+		if name == "" || name[0] == '<' {
+			continue
+		}
+		if c, ok := Lookup(name); !ok || c != r {
+			t.Errorf("char name [%s]: expected %U but %U (%v)", name, r, c, ok)
+		}
+	}
+}
+
+func TestParserResolver(t *testing.T) {
+	parser.SetCharNameResolver(Lookup)
+	defer parser.SetCharNameResolver(nil)
+	res, err := parser.Parse(`"\N{LATIN SMALL LETTER E WITH ACUTE}\N{HANGUL SYLLABLE GA}"`)
+	if err != nil {
+		t.Fatalf("parse error: %v", err.Msg)
+	}
+	if v := res[0].ToValue(); v != "\u00e9\uac00" {
+		t.Errorf("char names: %q", v)
+	}
+}
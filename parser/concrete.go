@@ -0,0 +1,171 @@
+package parser
+
+import (
+	"bytes"
+)
+
+// CST is a concrete syntax tree, which keeps the comments and spaces of
+// the source. String() prints the tree back to the same text as the
+// source, and the nodes replaced or inserted by the user are printed by
+// ToSExpString.
+type CST struct {
+	Nodes    []SExp
+	Trailing string // comments and spaces after the last node
+}
+
+// concrete holds the source text of an AST node around the children.
+type concrete struct {
+	before string // comments and spaces before the node, including the separator from the previous sibling
+	open   string // text before the first child, such as "(", or the whole text of a leaf
+	close  string // text after the last child, such as ")"
+	nchild int    // number of the children in the source
+	whole  bool   // open is the whole text of the node
+
+	// origs holds the children in the source, to print a new child which
+	// replaces the one at the same position with the same text before it.
+	origs []SExp
+}
+
+func cstOf(s SExp) *concrete {
+	if n, ok := s.(interface{ concreteSyntax() *concrete }); ok {
+		return n.concreteSyntax()
+	}
+	return nil
+}
+
+func (s *spanned) concreteSyntax() *concrete {
+	return s.cst
+}
+
+// ParseConcrete parses the source into a concrete syntax tree.
+func ParseConcrete(str string) (*CST, *Error) {
+	sexps, err := Parse(str)
+	if err != nil {
+		return nil, err
+	}
+	prev := 0
+	for _, s := range sexps {
+		attachConcrete(str, s, str[prev:s.Span().Start.Offset])
+		prev = int(s.Span().End.Offset)
+	}
+	return &CST{Nodes: sexps, Trailing: str[prev:]}, nil
+}
+
+// attachConcrete records the source text of the node and the descendants.
+func attachConcrete(src string, s SExp, before string) {
+	c := &concrete{before: before}
+	s.(interface{ setConcrete(*concrete) }).setConcrete(c)
+	start, end := int(s.Span().Start.Offset), int(s.Span().End.Offset)
+	cs := children(s)
+	c.nchild = len(cs)
+	c.origs = append([]SExp{}, cs...)
+	prev := start
+	for _, ch := range cs {
+		if int(ch.Span().Start.Offset) < prev {
+			// the children are not in the source order, such as the data
+			// of a hash table before the other properties
+			c.open, c.whole = src[start:end], true
+			return
+		}
+		prev = int(ch.Span().End.Offset)
+	}
+	if len(cs) == 0 {
+		c.open, c.whole = src[start:end], true
+		return
+	}
+	prev = start
+	for i, ch := range cs {
+		gap := src[prev:ch.Span().Start.Offset]
+		if i == 0 {
+			syntax := syntaxLength(gap)
+			c.open, gap = gap[:syntax], gap[syntax:]
+		}
+		attachConcrete(src, ch, gap)
+		prev = int(ch.Span().End.Offset)
+	}
+	c.close = src[prev:end]
+}
+
+func (s *spanned) setConcrete(c *concrete) {
+	s.cst = c
+}
+
+// syntaxLength returns the length of the text before the trailing comments
+// and spaces, such as 1 for "( ;comment\n".
+func syntaxLength(text string) int {
+	ret := 0
+	for i := 0; i < len(text); i++ {
+		switch b := text[i]; {
+		case isSpace(rune(b)):
+		case b == ';':
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case b == '"':
+			for i++; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+			ret = i + 1
+		default:
+			ret = i + 1
+		}
+	}
+	return ret
+}
+
+func (c *CST) String() string {
+	buf := bytes.Buffer{}
+	for i, s := range c.Nodes {
+		if cc := cstOf(s); cc != nil {
+			buf.WriteString(cc.before)
+		} else if i > 0 {
+			buf.WriteByte('\n')
+		}
+		writeConcrete(&buf, s)
+	}
+	buf.WriteString(c.Trailing)
+	return buf.String()
+}
+
+// ConcreteString prints the node in the concrete syntax, without the
+// comments and spaces before it.
+func ConcreteString(s SExp) string {
+	buf := bytes.Buffer{}
+	writeConcrete(&buf, s)
+	return buf.String()
+}
+
+func writeConcrete(buf *bytes.Buffer, s SExp) {
+	c := cstOf(s)
+	if c == nil {
+		buf.WriteString(s.ToSExpString())
+		return
+	}
+	cs := children(s)
+	if c.whole {
+		if len(cs) == c.nchild {
+			buf.WriteString(c.open)
+		} else {
+			buf.WriteString(s.ToSExpString()) // modified
+		}
+		return
+	}
+	current := make(map[SExp]bool, len(cs))
+	for _, ch := range cs {
+		current[ch] = true
+	}
+	buf.WriteString(c.open)
+	for i, ch := range cs {
+		if cc := cstOf(ch); cc != nil {
+			buf.WriteString(cc.before)
+		} else if i < len(c.origs) && !current[c.origs[i]] {
+			buf.WriteString(cstOf(c.origs[i]).before) // replaced
+		} else if i > 0 {
+			buf.WriteByte(' ')
+		}
+		writeConcrete(buf, ch)
+	}
+	buf.WriteString(c.close)
+}
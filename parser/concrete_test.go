@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"testing"
+)
+
+const concreteSrc = `;;; init.el --- my config  -*- lexical-binding: t -*-
+
+;; packages
+(setq package-archives
+      '(("gnu" . "https://elpa.gnu.org/packages/")   ; GNU
+        ("melpa" . "https://melpa.org/packages/"))) ; MELPA
+
+(defun my/hello (name)
+  "Say hello to NAME.
+With \"quotes\"; not a comment."
+  (interactive "sName: ")
+  (message "Hello %s%c" name ?\C-a)) ;; done
+
+[1  2 #xFF	3.0e+INF] #s(hash-table data (a 1) test equal) #s(rec 1)
+#1=(shared) #1# #("text" 0 4 (face bold)) #&3"\005" ( ) ( ;; empty
+)
+` + "`(a ,b ,@c) #'car (x . ( y . ;;tail\n z))" + `
+;; trailing comment
+`
+
+func TestConcreteRoundTrip(t *testing.T) {
+	cst, err := ParseConcrete(concreteSrc)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if s := cst.String(); s != concreteSrc {
+		t.Errorf("not round-tripped:\n%s", s)
+	}
+	for _, src := range []string{"", "  ", "; only comment", "a", " (a) "} {
+		cst, err := ParseConcrete(src)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if s := cst.String(); s != src {
+			t.Errorf("not round-tripped: [%s] -> [%s]", src, s)
+		}
+	}
+}
+
+func TestConcreteEdit(t *testing.T) {
+	src := "(setq a 1) ; one\n(setq b   ; bee\n      2)\n"
+	cst, err := ParseConcrete(src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	setq := cst.Nodes[1].(*SExpList)
+	setq.elements[2] = AstIntRadix(16, 16)
+	exp := "(setq a 1) ; one\n(setq b   ; bee\n      #x10)\n"
+	if s := cst.String(); s != exp {
+		t.Errorf("wrong replacement:\n%s", s)
+	}
+	setq.elements = append(setq.elements, AstSymbol("c"), AstString("d"))
+	exp = "(setq a 1) ; one\n(setq b   ; bee\n      #x10 c \"d\")\n"
+	if s := cst.String(); s != exp {
+		t.Errorf("wrong edit:\n%s", s)
+	}
+	cst.Nodes = append(cst.Nodes[1:], AstListv(AstSymbol("provide"), AstQ(AstSymbol("x"))))
+	exp = " ; one\n(setq b   ; bee\n      #x10 c \"d\")\n(provide 'x)\n"
+	if s := cst.String(); s != exp {
+		t.Errorf("wrong edit:\n%s", s)
+	}
+	if s := ConcreteString(setq); s != "(setq b   ; bee\n      #x10 c \"d\")" {
+		t.Errorf("wrong node string: %s", s)
+	}
+}
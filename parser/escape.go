@@ -0,0 +1,336 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Modifier bits of Emacs character codes
+const (
+	CharAlt   = 1 << 22
+	CharSuper = 1 << 23
+	CharHyper = 1 << 24
+	CharShift = 1 << 25
+	CharCtrl  = 1 << 26
+	CharMeta  = 1 << 27
+
+	charModifierMask = CharAlt | CharSuper | CharHyper | CharShift | CharCtrl | CharMeta
+)
+
+// readChar reads a character at src[i:], which may be an escape sequence
+// starting with backslash. It returns the character code and the next
+// position.
+func readChar(src string, i int) (int, int, error) {
+	if i >= len(src) {
+		return 0, i, fmt.Errorf("Missing character")
+	}
+	if src[i] == '\\' {
+		return readEscape(src, i+1)
+	}
+	r, w := utf8.DecodeRuneInString(src[i:])
+	return int(r), i + w, nil
+}
+
+// readEscape reads an escape sequence at src[i:], just after the backslash.
+// It returns the character code and the next position.
+func readEscape(src string, i int) (int, int, error) {
+	if i >= len(src) {
+		return 0, i, fmt.Errorf("Missing escape character")
+	}
+	r, w := utf8.DecodeRuneInString(src[i:])
+	i += w
+	switch r {
+	case 'a':
+		return 7, i, nil
+	case 'b':
+		return 8, i, nil
+	case 'd':
+		return 127, i, nil
+	case 'e':
+		return 27, i, nil
+	case 'f':
+		return 12, i, nil
+	case 'n':
+		return 10, i, nil
+	case 'r':
+		return 13, i, nil
+	case 't':
+		return 9, i, nil
+	case 'v':
+		return 11, i, nil
+	case 's':
+		if strings.HasPrefix(src[i:], "-") {
+			return readModified(src, i+1, CharSuper)
+		}
+		return ' ', i, nil
+	case 'M':
+		return readModifier(src, i, r, CharMeta)
+	case 'S':
+		return readModifier(src, i, r, CharShift)
+	case 'H':
+		return readModifier(src, i, r, CharHyper)
+	case 'A':
+		return readModifier(src, i, r, CharAlt)
+	case 'C':
+		if !strings.HasPrefix(src[i:], "-") {
+			return 0, i, fmt.Errorf("Invalid escape character syntax: \\%c", r)
+		}
+		return readControl(src, i+1)
+	case '^':
+		return readControl(src, i)
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		pe := i
+		for pe < len(src) && pe < i+2 && '0' <= src[pe] && src[pe] <= '7' {
+			pe++
+		}
+		c, _ := strconv.ParseInt(src[i-1:pe], 8, 32)
+		return int(c), pe, nil
+	case 'x':
+		pe := i
+		for pe < len(src) && digitValue(rune(src[pe])) < 16 {
+			pe++
+		}
+		if pe == i {
+			return 0, i, fmt.Errorf("Invalid escape character syntax: \\x")
+		}
+		c, err := strconv.ParseInt(src[i:pe], 16, 32)
+		if err != nil || c > unicode.MaxRune {
+			return 0, pe, fmt.Errorf("Hex character out of range: \\x%s", src[i:pe])
+		}
+		return int(c), pe, nil
+	case 'u':
+		return readUnicode(src, i, 4)
+	case 'U':
+		return readUnicode(src, i, 8)
+	case 'N':
+		return readCharName(src, i)
+	case '\n':
+		return 0, i, fmt.Errorf("Invalid escape character syntax: \\<newline>")
+	}
+	return int(r), i, nil
+}
+
+func readModifier(src string, i int, r rune, mod int) (int, int, error) {
+	if !strings.HasPrefix(src[i:], "-") {
+		return 0, i, fmt.Errorf("Invalid escape character syntax: \\%c", r)
+	}
+	return readModified(src, i+1, mod)
+}
+
+func readModified(src string, i int, mod int) (int, int, error) {
+	c, pe, err := readChar(src, i)
+	if err != nil {
+		return 0, pe, err
+	}
+	return c | mod, pe, nil
+}
+
+// readControl applies the control modifier in the same way as Emacs:
+// ASCII letters and @[\]^_ become control characters, ? becomes DEL,
+// and the others get the control modifier bit.
+func readControl(src string, i int) (int, int, error) {
+	c, pe, err := readChar(src, i)
+	if err != nil {
+		return 0, pe, err
+	}
+	mods := c & charModifierMask
+	base := c &^ charModifierMask
+	switch {
+	case base == '?':
+		return 127 | mods, pe, nil
+	case base >= 0x80:
+		return c | CharCtrl, pe, nil
+	case 'A' <= base&0x5f && base&0x5f <= 'Z':
+		return base&0x1f | mods, pe, nil
+	case '@' <= base && base <= '_':
+		return base&0x1f | mods, pe, nil
+	}
+	return c | CharCtrl, pe, nil
+}
+
+func readUnicode(src string, i int, digits int) (int, int, error) {
+	pe := i + digits
+	if pe > len(src) {
+		return 0, len(src), fmt.Errorf("Non-hex character used for Unicode escape: %s", src[i:])
+	}
+	c, err := strconv.ParseUint(src[i:pe], 16, 32)
+	if err != nil {
+		return 0, pe, fmt.Errorf("Non-hex character used for Unicode escape: %s", src[i:pe])
+	}
+	if c > unicode.MaxRune {
+		return 0, pe, fmt.Errorf("Non-Unicode character: 0x%x", c)
+	}
+	return int(c), pe, nil
+}
+
+func readCharName(src string, i int) (int, int, error) {
+	if !strings.HasPrefix(src[i:], "{") {
+		return 0, i, fmt.Errorf("Expected opening brace after \\N")
+	}
+	pe := strings.IndexByte(src[i:], '}')
+	if pe < 0 {
+		return 0, len(src), fmt.Errorf("Expected closing brace after \\N{")
+	}
+	name := src[i+1 : i+pe]
+	r, ok := LookupCharName(name)
+	if !ok {
+		return 0, i + pe + 1, fmt.Errorf("Invalid character name: %s", name)
+	}
+	return int(r), i + pe + 1, nil
+}
+
+// charNameResolver resolves the character names other than "U+XXXX".
+var charNameResolver atomic.Pointer[func(name string) (rune, bool)]
+
+// SetCharNameResolver sets the function to resolve the Unicode character
+// names in \N{...}, such as charnames.Lookup. The function receives the
+// name in upper case with single spaces. Without the resolver, only the
+// names of the code points, such as "U+E9", are accepted. A nil function
+// removes the resolver.
+func SetCharNameResolver(f func(name string) (rune, bool)) {
+	if f == nil {
+		charNameResolver.Store(nil)
+	} else {
+		charNameResolver.Store(&f)
+	}
+}
+
+// LookupCharName returns the character for a Unicode name, such as "U+E9",
+// or the one resolved by the function of SetCharNameResolver. The name is
+// case-insensitive.
+func LookupCharName(name string) (rune, bool) {
+	name = strings.ToUpper(strings.Join(strings.Fields(name), " "))
+	if strings.HasPrefix(name, "U+") {
+		c, err := strconv.ParseUint(name[2:], 16, 32)
+		if err != nil || c > unicode.MaxRune {
+			return 0, false
+		}
+		return rune(c), true
+	}
+	if f := charNameResolver.Load(); f != nil {
+		return (*f)(name)
+	}
+	return 0, false
+}
+
+// CharCode returns the character code of a character literal without the
+// leading '?', such as "a", "\n" or "\C-x".
+func CharCode(literal string) (int, error) {
+	c, pe, err := readChar(literal, 0)
+	if err != nil {
+		return 0, err
+	}
+	if pe != len(literal) {
+		return 0, fmt.Errorf("Invalid character literal: ?%s", literal)
+	}
+	return c, nil
+}
+
+var charEscapes = map[int]string{
+	7: `\a`, 8: `\b`, 9: `\t`, 10: `\n`, 11: `\v`, 12: `\f`, 13: `\r`,
+	27: `\e`, 32: `\s`, 127: `\d`,
+}
+
+// convert character literal
+// ex: CharLiteral('a') -> ?a, CharLiteral(CharMeta|'x') -> ?\M-x
+func CharLiteral(code int) string {
+	buf := strings.Builder{}
+	buf.WriteByte('?')
+	mods := []struct {
+		bit    int
+		prefix string
+	}{
+		{CharAlt, `\A-`}, {CharSuper, `\s-`}, {CharHyper, `\H-`},
+		{CharShift, `\S-`}, {CharCtrl, `\C-`}, {CharMeta, `\M-`},
+	}
+	for _, m := range mods {
+		if code&m.bit != 0 {
+			buf.WriteString(m.prefix)
+		}
+	}
+	base := code &^ charModifierMask
+	switch {
+	case charEscapes[base] != "":
+		buf.WriteString(charEscapes[base])
+	case base == 0:
+		buf.WriteString(`\C-@`)
+	case base < 27:
+		buf.WriteString(`\C-`)
+		buf.WriteByte(byte(base) + '`') // 1 -> a
+	case base < 32:
+		buf.WriteString(`\C-`)
+		if base == 28 {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(byte(base) + '@')
+	case strings.ContainsRune(`()[]"';?\#,`+"`", rune(base)):
+		buf.WriteByte('\\')
+		buf.WriteByte(byte(base))
+	case base > unicode.MaxRune:
+		buf.WriteString(fmt.Sprintf(`\x%x`, base))
+	case !unicode.IsPrint(rune(base)):
+		if base > 0xffff {
+			buf.WriteString(fmt.Sprintf(`\U%08x`, base))
+		} else {
+			buf.WriteString(fmt.Sprintf(`\u%04x`, base))
+		}
+	default:
+		buf.WriteRune(rune(base))
+	}
+	return buf.String()
+}
+
+// UnescapeString decodes the content of a string literal without the
+// double quotes, such as `a\nb`. Octal and hex escapes from \200 to \377
+// are decoded into raw bytes, as Emacs makes a unibyte string of them.
+func UnescapeString(lit string) (string, error) {
+	if strings.IndexByte(lit, '\\') < 0 {
+		return lit, nil
+	}
+	buf := strings.Builder{}
+	buf.Grow(len(lit))
+	for i := 0; i < len(lit); {
+		pe := strings.IndexByte(lit[i:], '\\')
+		if pe < 0 {
+			buf.WriteString(lit[i:])
+			break
+		}
+		buf.WriteString(lit[i : i+pe])
+		i += pe + 1
+		if i >= len(lit) {
+			return "", fmt.Errorf("Missing escape character")
+		}
+		switch lit[i] {
+		case '\n', ' ': // ignored
+			i++
+			continue
+		case 's': // always space in strings
+			buf.WriteByte(' ')
+			i++
+			continue
+		}
+		c, pe, err := readEscape(lit, i)
+		if err != nil {
+			return "", err
+		}
+		numeric := strings.IndexByte("01234567x", lit[i]) >= 0
+		i = pe
+		if c&charModifierMask == CharMeta && c&^CharMeta < 0x80 {
+			buf.WriteByte(byte(c&^CharMeta) | 0x80) // meta ASCII in strings
+			continue
+		}
+		if c&charModifierMask != 0 {
+			return "", fmt.Errorf("Invalid modifier in string: %s", CharLiteral(c))
+		}
+		if numeric && 0x80 <= c && c <= 0xff {
+			buf.WriteByte(byte(c))
+		} else {
+			buf.WriteRune(rune(c))
+		}
+	}
+	return buf.String(), nil
+}
@@ -4,9 +4,9 @@ package parser
 
 import "fmt"
 
-const _itemType_name = "itemErroritemSpaceitemFloatitemIntegeritemSymbolitemStringitemDotitemCharLititemCharitemCommentitemEOF"
+const _itemType_name = "itemErroritemSpaceitemFloatitemIntegeritemSymbolitemStringitemDotitemCharLititemCharitemCommentitemRecorditemBoolVectoritemLabelitemLabelRefitemEOF"
 
-var _itemType_index = [...]uint8{0, 9, 18, 27, 38, 48, 58, 65, 76, 84, 95, 102}
+var _itemType_index = [...]uint8{0, 9, 18, 27, 38, 48, 58, 65, 76, 84, 95, 105, 119, 128, 140, 147}
 
 func (i itemType) String() string {
 	i -= 1
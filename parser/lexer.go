@@ -3,9 +3,11 @@ package parser
 import (
 	"fmt"
 	"runtime"
+	"sort"
 	"strings"
 	"unicode"
 	"unicode/utf8"
+	"unsafe"
 )
 
 // Pos : Offset position of source data
@@ -15,6 +17,7 @@ type item struct {
 	itype itemType
 	pos   Pos
 	val   string
+	text  string // the unescaped value of itemString
 }
 
 type itemType int
@@ -33,6 +36,10 @@ const (
 	itemCharLit
 	itemChar
 	itemComment
+	itemRecord     // #s prefix of hash tables and records
+	itemBoolVector // #&N"..."
+	itemLabel      // #N=
+	itemLabelRef   // #N#
 	itemEOF
 )
 
@@ -51,11 +58,13 @@ type Lexer struct {
 	lastPos Pos       // position of most recent item returned by nextItem
 	items   chan item // channel of scanned items
 	result  []SExp    // parser result objects
-	error   *Error    // error interface
+	error   *Error    // the first error
+	errors  []*Error  // all of the errors
+	lines   []int     // offsets of the line heads
+	shared  bool      // the input is a view of a reusable buffer
 }
 
 type Error struct {
-	error
 	Msg  string // error message
 	Pos  Pos    // offset position
 	Line int    // line position
@@ -63,6 +72,24 @@ type Error struct {
 	Text string // error line
 }
 
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Position is a location in the source. Line and Col are 1-origin, and
+// Col counts bytes like Error.
+type Position struct {
+	Offset Pos
+	Line   int
+	Col    int
+}
+
+// Span is the source range of an AST node, from Start to just before End.
+type Span struct {
+	Start Position
+	End   Position
+}
+
 func (s *Lexer) Init(input string) {
 	s.input = input
 	s.pos = 0
@@ -97,17 +124,45 @@ func (s *Lexer) peekNext(n int) (string, bool) {
 	return "", false
 }
 
+// bytesToString returns a string sharing the memory with the bytes.
+func bytesToString(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	return unsafe.String(&b[0], len(b))
+}
+
+// own copies the string out of the shared input, so that the AST is
+// not broken when the buffer is reused.
+func (s *Lexer) own(str string) string {
+	if !s.shared || len(str) == 0 {
+		return str
+	}
+	p := uintptr(unsafe.Pointer(unsafe.StringData(str)))
+	base := uintptr(unsafe.Pointer(unsafe.StringData(s.input)))
+	if p < base || p >= base+uintptr(len(s.input)) {
+		return str // unescaped already
+	}
+	return strings.Clone(str)
+}
+
 func (s *Lexer) backup() {
 	s.pos -= s.width
 }
 
 func (s *Lexer) emit(t itemType) {
-	v := item{t, s.start, s.input[s.start:s.pos]}
+	v := item{itype: t, pos: s.start, val: s.input[s.start:s.pos]}
 	//pp.Printf("EMIT: %v (%v)\n", v, int(s.pos))
 	s.items <- v
 	s.start = s.pos
 }
 
+// emitString emits the string literal with the unescaped value.
+func (s *Lexer) emitString(text string) {
+	s.items <- item{itype: itemString, pos: s.start, val: s.input[s.start:s.pos], text: text}
+	s.start = s.pos
+}
+
 func (s *Lexer) ignore() {
 	s.start = s.pos
 }
@@ -120,48 +175,82 @@ func (s *Lexer) accept(valid string) bool {
 	return false
 }
 
+func (s *Lexer) acceptString(str string) bool {
+	if strings.HasPrefix(s.input[s.pos:], str) {
+		s.pos += Pos(len(str))
+		return true
+	}
+	return false
+}
+
 func (s *Lexer) acceptRun(valid string) {
 	for strings.ContainsRune(valid, s.next()) {
 	}
 	s.backup()
 }
 
-func (s *Lexer) lineNumber() int {
-	return 1 + strings.Count(s.input[:s.lastPos], "\n")
+// position returns the line and column of the offset position.
+func (s *Lexer) position(p Pos) Position {
+	if s.lines == nil {
+		s.lines = []int{0}
+		for i := 0; i < len(s.input); i++ {
+			if s.input[i] == '\n' {
+				s.lines = append(s.lines, i+1)
+			}
+		}
+	}
+	ln := sort.SearchInts(s.lines, int(p)+1) - 1
+	return Position{Offset: p, Line: ln + 1, Col: int(p) - s.lines[ln] + 1}
 }
 
-func (s *Lexer) columnNumber() int {
-	ps := strings.LastIndex(s.input[:s.lastPos], "\n")
-	if ps == -1 {
-		return int(s.lastPos) + 1
-	}
-	//pp.Printf("PS: %v,  lastpos: %v\n", ps, int(s.lastPos))
-	return int(s.lastPos) - ps
+func (s *Lexer) lineNumber(p Pos) int {
+	return s.position(p).Line
+}
+
+func (s *Lexer) columnNumber(p Pos) int {
+	return s.position(p).Col
 }
 
-func (s *Lexer) errorLineText() string {
+func (s *Lexer) errorLineText(p Pos) string {
 	var ret string
-	//pp.Printf("s.lastPos: %v,  s.last: %v\n", s.lastPos, len(s.input))
-	ps := strings.LastIndex(s.input[:s.lastPos], "\n")
+	//pp.Printf("p: %v,  s.last: %v\n", p, len(s.input))
+	ps := strings.LastIndex(s.input[:p], "\n")
 	if ps == -1 {
 		ps = 0
 	}
-	pe := strings.Index(s.input[s.lastPos:], "\n")
+	pe := strings.Index(s.input[p:], "\n")
 	if pe == -1 {
 		pe = len(s.input)
 	} else {
-		pe += int(s.lastPos)
+		pe += int(p)
 	}
 	//pp.Printf("start: %v,  end:%v\n", ps, pe)
 	ret = s.input[ps:pe]
-	ret += "\n" + strings.Repeat(" ", s.columnNumber()-1) + "^"
+	ret += "\n" + strings.Repeat(" ", s.columnNumber(p)-1) + "^"
 	return ret
 
 }
 
+// errorf emits an error item, and then skips the rest of the invalid token
+// to continue scanning.
 func (s *Lexer) errorf(format string, args ...interface{}) stateFn {
-	s.items <- item{itemError, s.start, fmt.Sprintf(format, args...)}
-	return nil
+	s.items <- item{itype: itemError, pos: s.start, val: fmt.Sprintf(format, args...)}
+	return scanInvalidRest
+}
+
+func scanInvalidRest(s *Lexer) stateFn {
+	for {
+		r := s.next()
+		if r == EOF {
+			return nil
+		}
+		if isSpace(r) || strings.ContainsRune("()[]\"';", r) {
+			s.backup()
+			break
+		}
+	}
+	s.ignore()
+	return scanNextAction
 }
 
 func (s *Lexer) nextItem() item {
@@ -195,6 +284,8 @@ func scanNextAction(s *Lexer) stateFn {
 		return scanCommentRest
 	case r == '?':
 		return scanCharLiteral
+	case r == '#':
+		return scanSharp
 	case r == '.' || r == '-' || r == '+' || isDigit(r):
 		s.backup()
 		return scanNumberOrSymbol
@@ -225,21 +316,77 @@ Loop:
 }
 
 func scanCharLiteral(s *Lexer) stateFn {
-	r := s.next()
-	if r == EOF {
-		return nil
+	_, pe, err := readChar(s.input, int(s.pos))
+	if err != nil {
+		return s.errorf("Invalid character literal: %v", err)
 	}
-	if r == '\\' {
-		rr := s.next()
-		if rr == EOF {
-			return nil
+	s.pos = Pos(pe)
+	s.emit(itemCharLit)
+	return scanNextAction
+}
+
+func scanSharp(s *Lexer) stateFn {
+	switch r := s.peek(); {
+	case strings.ContainsRune("xXoObB", r):
+		return scanRadixInteger
+	case r == '#':
+		s.next()
+		s.emit(itemSymbol) // ##: the empty symbol
+		return scanSpace
+	case r == 's':
+		if rest, ok := s.peekNext(2); ok && rest == "s(" {
+			s.next()
+			s.emit(itemRecord) // #s(hash-table ...), #s(name slot ...)
+			return scanNextAction
+		}
+	case r == '&':
+		return scanBoolVector
+	case isDigit(r):
+		rest := strings.TrimLeft(s.input[s.pos:], "0123456789")
+		if strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R") {
+			return scanRadixInteger // ex: #2r1, #24r1k
+		}
+		if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, "#") {
+			s.acceptRun("0123456789")
+			if s.accept("=") {
+				s.emit(itemLabel) // ex: #1=(a . #1#)
+				return scanNextAction
+			}
+			s.next()
+			s.emit(itemLabelRef)
+			return scanSpace
 		}
 	}
-
-	s.emit(itemCharLit)
+	s.emit(itemChar) // '#' prefix of the other syntax
 	return scanNextAction
 }
 
+func scanRadixInteger(s *Lexer) stateFn {
+	if !s.accept("xXoObB") {
+		s.acceptRun("0123456789")
+		s.accept("rR")
+	}
+	radix, _ := radixOf(s.input[s.start:s.pos])
+	if radix < 2 || radix > 36 {
+		return s.errorf("Invalid radix: %s", s.input[s.start:s.pos])
+	}
+	s.accept("+-")
+	digits := s.pos
+	for isDigit(s.peek()) || isAlphabet(s.peek()) {
+		s.next()
+	}
+	if digits == s.pos {
+		return s.errorf("Missing digits: %s", s.input[s.start:s.pos])
+	}
+	for _, r := range s.input[digits:s.pos] {
+		if digitValue(r) >= radix {
+			return s.errorf("Invalid digit for radix %d: %s", radix, s.input[s.start:s.pos])
+		}
+	}
+	s.emit(itemInteger)
+	return scanSpace
+}
+
 func scanNumberOrSymbol(s *Lexer) stateFn {
 	r := s.next()
 
@@ -294,14 +441,25 @@ func scanNumber(s *Lexer) stateFn {
 	s.accept("+-")
 	digits := "0123456789"
 	s.acceptRun(digits)
-	if s.accept(".") {
+	if s.accept(".") && isDigit(s.peek()) {
+		// "1." is an integer in Emacs
 		s.acceptRun(digits)
 		item = itemFloat
 	}
 	if s.accept("eE") {
-		s.accept("+-")
-		s.acceptRun(digits)
-		item = itemFloat
+		if s.acceptString("+INF") || s.acceptString("+NaN") {
+			item = itemFloat
+		} else {
+			s.accept("+-")
+			if !isDigit(s.peek()) {
+				return scanSymbolRest // ex: 1e
+			}
+			s.acceptRun(digits)
+			item = itemFloat
+		}
+	}
+	if isSymbolRest(s.peek()) {
+		return scanSymbolRest // ex: 1+, 1-
 	}
 
 	s.emit(item)
@@ -331,8 +489,22 @@ Loop:
 	return scanSpace
 }
 
-func scanStringRest(s *Lexer) stateFn {
-Loop:
+func scanBoolVector(s *Lexer) stateFn {
+	s.next() // &
+	s.acceptRun("0123456789")
+	if !s.accept(`"`) || !scanStringBody(s) {
+		return s.errorf("Invalid bool-vector: %s", s.input[s.start:s.pos])
+	}
+	if _, err := parseBoolVector(s.input[s.start:s.pos]); err != nil {
+		return s.errorf("Invalid bool-vector: %v", err)
+	}
+	s.emit(itemBoolVector)
+	return scanSpace
+}
+
+// scanStringBody reads a string content until the closing double quote.
+// It returns false when the string is not terminated.
+func scanStringBody(s *Lexer) bool {
 	for {
 		switch s.next() {
 		case '\\':
@@ -341,12 +513,22 @@ Loop:
 			}
 			fallthrough
 		case EOF:
-			return s.errorf("Unterminated string literal")
+			return false
 		case '"':
-			break Loop
+			return true
 		}
 	}
-	s.emit(itemString)
+}
+
+func scanStringRest(s *Lexer) stateFn {
+	if !scanStringBody(s) {
+		return s.errorf("Unterminated string literal")
+	}
+	text, err := UnescapeString(s.input[s.start+1 : s.pos-1])
+	if err != nil {
+		return s.errorf("Invalid string literal: %v", err)
+	}
+	s.emitString(text)
 	return scanSpace
 }
 
@@ -372,6 +554,20 @@ func isDigit(r rune) bool {
 	return unicode.IsDigit(r)
 }
 
+// digitValue returns the value of a digit in radix notation, or 36 for
+// an invalid digit.
+func digitValue(r rune) int {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r - '0')
+	case 'a' <= r && r <= 'z':
+		return int(r-'a') + 10
+	case 'A' <= r && r <= 'Z':
+		return int(r-'A') + 10
+	}
+	return 36
+}
+
 func isAlphabet(r rune) bool {
 	return unicode.IsLetter(r)
 }
@@ -381,5 +577,5 @@ func isSymbolHead(r rune) bool {
 }
 
 func isSymbolRest(r rune) bool {
-	return isAlphabet(r) || isDigit(r) || strings.ContainsRune("+-*/_~!$%^&=:<>{}.|", r) || r > 255
+	return isAlphabet(r) || isDigit(r) || strings.ContainsRune("+-*/_~!$%^&=:<>{}.|?", r) || r > 255
 }
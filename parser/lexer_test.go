@@ -98,6 +98,13 @@ func TestString(t *testing.T) {
 	testItem(t, i, itemString, `"abcd"`, 0)
 	i = runScan(`"aa\naa\"bb\\cc"`)
 	testItem(t, i, itemString, `"aa\naa\"bb\\cc"`, 0)
+	if i.text != "aa\naa\"bb\\cc" {
+		t.Errorf("itemText: [%s] is not unescaped", i.text)
+	}
+	i = runScan(`"a\N{NO SUCH NAME}"`)
+	if i.itype != itemError {
+		t.Errorf("itemType: %s is not %s", i.itype, itemError)
+	}
 }
 
 func TestChars(t *testing.T) {
@@ -125,3 +132,87 @@ func TestSequence(t *testing.T) {
 		itemSymbol, itemSpace, itemString, itemChar,
 	})
 }
+
+func TestSpecialFloat(t *testing.T) {
+	i := runScan("1.0e+INF")
+	testItem(t, i, itemFloat, "1.0e+INF", 0)
+	i = runScan("-1.0e+INF")
+	testItem(t, i, itemFloat, "-1.0e+INF", 0)
+	i = runScan("0.0e+NaN")
+	testItem(t, i, itemFloat, "0.0e+NaN", 0)
+	i = runScan("1e+06")
+	testItem(t, i, itemFloat, "1e+06", 0)
+}
+
+func TestNumberLikeSymbol(t *testing.T) {
+	i := runScan("1.")
+	testItem(t, i, itemInteger, "1.", 0)
+	i = runScan("1+")
+	testItem(t, i, itemSymbol, "1+", 0)
+	i = runScan("1-")
+	testItem(t, i, itemSymbol, "1-", 0)
+	i = runScan("1e")
+	testItem(t, i, itemSymbol, "1e", 0)
+}
+
+func TestRadixInteger(t *testing.T) {
+	i := runScan("#xFF")
+	testItem(t, i, itemInteger, "#xFF", 0)
+	i = runScan("#o17")
+	testItem(t, i, itemInteger, "#o17", 0)
+	i = runScan("#b101")
+	testItem(t, i, itemInteger, "#b101", 0)
+	i = runScan("#36rZZ")
+	testItem(t, i, itemInteger, "#36rZZ", 0)
+	i = runScan("#x-1f")
+	testItem(t, i, itemInteger, "#x-1f", 0)
+	i = runScan("#b102")
+	testItem(t, i, itemError, "Invalid digit for radix 2: #b102", 0)
+	i = runScan("#37r1")
+	testItem(t, i, itemError, "Invalid radix: #37r", 0)
+	i = runScan("#x")
+	testItem(t, i, itemError, "Missing digits: #x", 0)
+}
+
+func TestCharLiterals(t *testing.T) {
+	SetCharNameResolver(testCharName)
+	defer SetCharNameResolver(nil)
+	data := []string{
+		`?a`, `?\n`, `?\C-a`, `?\^I`, `?\M-x`, `?\C-\M-x`, `?\s-a`, `?\s`,
+		`?\101`, `?\x41`, `?é`, `?\U0001F600`, `?\N{LATIN SMALL LETTER E WITH ACUTE}`,
+	}
+	for _, v := range data {
+		i := runScan(v)
+		testItem(t, i, itemCharLit, v, 0)
+	}
+	items := runSeq(`(?\C-x ?b)`)
+	checkSeq(t, `(?\C-x ?b)`, items, []itemType{
+		itemChar, itemCharLit, itemSpace, itemCharLit, itemChar,
+	})
+	i := runScan(`?\C`)
+	testItem(t, i, itemError, `Invalid character literal: Invalid escape character syntax: \C`, 0)
+}
+
+func TestRecordAndBoolVector(t *testing.T) {
+	items := runSeq(`#s(foo 1)`)
+	checkSeq(t, `#s(foo 1)`, items, []itemType{
+		itemRecord, itemChar, itemSymbol, itemSpace, itemInteger, itemChar,
+	})
+	i := runScan(`#&5"\37"`)
+	testItem(t, i, itemBoolVector, `#&5"\37"`, 0)
+	i = runScan(`#&0""`)
+	testItem(t, i, itemBoolVector, `#&0""`, 0)
+	i = runScan(`#&9"a"`)
+	testItem(t, i, itemError, `Invalid bool-vector: length 9 does not match the data: #&9"a"`, 0)
+	i = runScan(`#&3"a`)
+	testItem(t, i, itemError, `Invalid bool-vector: #&3"a`, 0)
+}
+
+func TestLabels(t *testing.T) {
+	items := runSeq(`#1=(a . #1#)`)
+	checkSeq(t, `#1=(a . #1#)`, items, []itemType{
+		itemLabel, itemChar, itemSymbol, itemSpace, itemDot, itemSpace, itemLabelRef, itemChar,
+	})
+	i := runScan(`#12#`)
+	testItem(t, i, itemLabelRef, `#12#`, 0)
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/k0kubun/pp"
@@ -10,7 +11,30 @@ import (
 
 /// test utils
 
+// testCharName resolves a few names in place of charnames.Lookup.
+func testCharName(name string) (rune, bool) {
+	switch name {
+	case "LATIN SMALL LETTER E WITH ACUTE":
+		return 0xe9, true
+	case "GRINNING FACE":
+		return 0x1f600, true
+	}
+	return 0, false
+}
+
+// clearSpans removes the source ranges to compare with the constructed AST.
+func clearSpans(s SExp) {
+	if s == nil {
+		return
+	}
+	s.(interface{ setSpan(Span) }).setSpan(Span{})
+	for _, c := range children(s) {
+		clearSpans(c)
+	}
+}
+
 func compareString(t *testing.T, msg string, v1 SExp, v2 SExp) {
+	clearSpans(v1)
 	pp.ColoringEnabled = false
 	s1 := pp.Sprintf("%v", v1)
 	s2 := pp.Sprintf("%v", v2)
@@ -204,3 +228,551 @@ func TestCharLiteral(t *testing.T) {
 		testSExp(t, k, v.src, v.exp)
 	}
 }
+
+func TestRadixIntegerValue(t *testing.T) {
+	data := map[string]int{
+		"#xFF":   255,
+		"#xff":   255,
+		"#o17":   15,
+		"#b101":  5,
+		"#36rZZ": 1295,
+		"#24r1k": 44,
+		"#x-10":  -16,
+	}
+	for src, exp := range data {
+		res, err := Parse(src)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", src, err.Msg)
+			continue
+		}
+		if v := res[0].ToValue(); v != exp {
+			t.Errorf("radix integer [%s]: expected %d but %v", src, exp, v)
+		}
+	}
+	if s := AstIntRadix(0xff00ff, 16).ToSExpString(); s != "#xff00ff" {
+		t.Errorf("radix integer literal: %s", s)
+	}
+	if s := AstIntRadix(5, 2).ToSExpString(); s != "#b101" {
+		t.Errorf("radix integer literal: %s", s)
+	}
+	if s := AstIntRadix(44, 24).ToSExpString(); s != "#24r1k" {
+		t.Errorf("radix integer literal: %s", s)
+	}
+}
+
+func TestCharLiteralValue(t *testing.T) {
+	SetCharNameResolver(testCharName)
+	defer SetCharNameResolver(nil)
+	data := map[string]int{
+		`?a`:                                   'a',
+		`?\(`:                                  '(',
+		`?\\`:                                  '\\',
+		`?\n`:                                  10,
+		`?\s`:                                  32,
+		`?\d`:                                  127,
+		`?\e`:                                  27,
+		`?\C-a`:                                1,
+		`?\C-A`:                                1,
+		`?\^I`:                                 9,
+		`?\^?`:                                 127,
+		`?\C-@`:                                0,
+		`?\C-1`:                                CharCtrl | '1',
+		`?\M-x`:                                CharMeta | 'x',
+		`?\C-\M-x`:                             CharMeta | 24,
+		`?\M-\C-x`:                             CharMeta | 24,
+		`?\s-a`:                                CharSuper | 'a',
+		`?\H-a`:                                CharHyper | 'a',
+		`?\A-a`:                                CharAlt | 'a',
+		`?\S-a`:                                CharShift | 'a',
+		`?\101`:                                'A',
+		`?\x41`:                                'A',
+		`?é`:                                   0xe9,
+		`?\U0001F600`:                          0x1f600,
+		`?\N{LATIN SMALL LETTER E WITH ACUTE}`: 0xe9,
+		`?\N{latin small letter e with acute}`: 0xe9,
+		`?\N{U+1F600}`:                         0x1f600,
+	}
+	for src, exp := range data {
+		res, err := Parse(src)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", src, err.Msg)
+			continue
+		}
+		if v := res[0].ToValue(); v != exp {
+			t.Errorf("char literal [%s]: expected %d but %v", src, exp, v)
+		}
+		if v := res[0].ToValueWith(&ValueOptions{CharAsRune: true}); v != rune(exp) {
+			t.Errorf("char literal as rune [%s]: expected %d but %v", src, exp, v)
+		}
+	}
+}
+
+func TestCharLiteralRoundTrip(t *testing.T) {
+	codes := []int{
+		0, 1, 7, 9, 10, 26, 27, 28, 31, 32, 'a', 'Z', '(', '?', '\\', '"', 127, 0xe9, 0x1f600, 0x200b,
+		CharMeta | 'x', CharMeta | 24, CharSuper | CharHyper | 'a', CharCtrl | '1',
+	}
+	for _, c := range codes {
+		lit := CharLiteral(c)
+		res, err := Parse(lit)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", lit, err.Msg)
+			continue
+		}
+		if v := res[0].ToValue(); v != c {
+			t.Errorf("char code [%d]: %s -> %v", c, lit, v)
+		}
+		if s := AstCharCode(c).ToSExpString(); s != lit {
+			t.Errorf("char code [%d]: %s != %s", c, s, lit)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	data := map[string]string{
+		`"a\nb"`:           "a\nb",
+		`"a\"b"`:           `a"b`,
+		`"a\\b"`:           `a\b`,
+		`"\t\r\f\a\b\v\e"`: "\t\r\f\a\b\v\x1b",
+		`"\s\d"`:           " \x7f",
+		`"\x41\ B"`:        "AB",
+		`"\x3b1"`:          "α",
+		`"é\U0001F600"`:    "é\U0001F600",
+		`"\101\0"`:         "A\x00",
+		`"\351"`:           "\xe9",
+		`"\xe9"`:           "\xe9",
+		"\"a\\\nb\"":       "ab",
+		`"\C-a\^b"`:        "\x01\x02",
+		`"\M-a"`:           "\xe1",
+		`"\N{U+E9}"`:       "é",
+		`"\("`:             "(",
+	}
+	for src, exp := range data {
+		res, err := Parse(src)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", src, err.Msg)
+			continue
+		}
+		if v := res[0].ToValue(); v != exp {
+			t.Errorf("string literal [%s]: expected %q but %q", src, exp, v)
+		}
+	}
+}
+
+func TestStringEscapeError(t *testing.T) {
+	res, err := Parse(`("ok" "\M-\C-\N{NO SUCH NAME}")`)
+	if err == nil {
+		pp.Println(res)
+		t.Fatal("Error should be returned.")
+	}
+	if err.Msg != "Invalid string literal: Invalid character name: NO SUCH NAME" || err.Col != 7 {
+		t.Errorf("wrong error: %s (col=%d)", err.Msg, err.Col)
+	}
+}
+
+func TestSymbolValue(t *testing.T) {
+	o := &ValueOptions{PreserveSymbols: true}
+	data := map[string]interface{}{
+		`foo`:        Symbol("foo"),
+		`:key`:       Keyword("key"),
+		`"foo"`:      "foo",
+		`t`:          true,
+		`nil`:        nil,
+		`foo\ bar`:   Symbol("foo bar"),
+		`\1`:         Symbol("1"),
+		`##`:         Symbol(""),
+		`major-mode`: Symbol("major-mode"),
+	}
+	for src, exp := range data {
+		res, err := Parse(src)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", src, err.Msg)
+			continue
+		}
+		if v := res[0].ToValueWith(o); v != exp {
+			t.Errorf("symbol [%s]: expected %#v but %#v", src, exp, v)
+		}
+	}
+	res, _ := Parse(`foo`)
+	if v := res[0].ToValue(); v != "foo" {
+		t.Errorf("symbol without option: %#v", v)
+	}
+}
+
+func TestLookupCharName(t *testing.T) {
+	if r, ok := LookupCharName("u+e9"); !ok || r != 0xe9 {
+		t.Errorf("char name [u+e9]: %U (%v)", r, ok)
+	}
+	for _, name := range []string{"LATIN SMALL LETTER E WITH ACUTE", "U+110000", "U+XYZ"} {
+		if r, ok := LookupCharName(name); ok {
+			t.Errorf("char name [%s] without resolver: found %U", name, r)
+		}
+	}
+	SetCharNameResolver(testCharName)
+	defer SetCharNameResolver(nil)
+	if r, ok := LookupCharName("latin  small letter e with acute"); !ok || r != 0xe9 {
+		t.Errorf("char name with resolver: %U (%v)", r, ok)
+	}
+	if r, ok := LookupCharName("NO SUCH NAME"); ok {
+		t.Errorf("char name [NO SUCH NAME]: found %U", r)
+	}
+}
+
+func TestSymbolLiteralRoundTrip(t *testing.T) {
+	names := []string{"foo", "a b", "(x)", "1", "-1.5", "1e+INF", ".", "", `back\slash`, "?x", "a;b", "'q", "#x"}
+	for _, n := range names {
+		lit := SymbolLiteral(n)
+		res, err := Parse(lit)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", lit, err.Msg)
+			continue
+		}
+		if len(res) != 1 {
+			t.Errorf("symbol literal [%s] is read as %d objects", lit, len(res))
+			continue
+		}
+		if v := res[0].ToValueWith(&ValueOptions{PreserveSymbols: true}); v != Symbol(n) {
+			t.Errorf("symbol [%q]: %s -> %#v", n, lit, v)
+		}
+	}
+}
+
+func TestSymbolLiteralInvalidUTF8(t *testing.T) {
+	if lit, err := SymbolLiteralChecked("a\xffb"); err == nil {
+		t.Errorf("invalid UTF-8 is converted: %q", lit)
+	}
+	res, err := Parse("a\xff\\ b")
+	if err != nil {
+		t.Fatalf("parse error: %v", err.Msg)
+	}
+	if s := res[0].ToSExpString(); s != "a\xff\\ b" {
+		t.Errorf("raw bytes are not kept: %q", s)
+	}
+}
+
+func TestConsValue(t *testing.T) {
+	o := &ValueOptions{PreserveCons: true}
+	data := map[string]interface{}{
+		`(1 . 2)`:         Cons{1, 2},
+		`(1 2 . 3)`:       Cons{1, Cons{2, 3}},
+		`(1 . (2 3))`:     []int{1, 2, 3},
+		`(1 . nil)`:       []int{1},
+		`(1 2 . (3 . 4))`: Cons{1, Cons{2, Cons{3, 4}}},
+		`(1 2)`:           []int{1, 2},
+	}
+	for src, exp := range data {
+		res, err := Parse(src)
+		if err != nil {
+			t.Errorf("parse error [%s]: %v", src, err.Msg)
+			continue
+		}
+		if v := res[0].ToValueWith(o); !reflect.DeepEqual(v, exp) {
+			t.Errorf("cons [%s]: expected %#v but %#v", src, exp, v)
+		}
+	}
+	res, _ := Parse(`(1 . 2)`)
+	if v := res[0].ToValue(); !reflect.DeepEqual(v, []int{1, 2}) {
+		t.Errorf("cons without option: %#v", v)
+	}
+}
+
+func TestHashTableAndRecord(t *testing.T) {
+	data := map[string]srcexp{
+		"hash table": srcexp{`#s(hash-table test equal data ("a" 1 b (2)))`,
+			AstHashTable("equal", AstString("a"), AstInt("1"), AstSymbol("b"), AstListv(AstInt("2"))),
+		},
+		"empty hash table": srcexp{`#s(hash-table size 0 data ())`,
+			&SExpHashTable{props: []SExp{AstSymbol("size"), AstInt("0")}},
+		},
+		"record": srcexp{`#s(foo 1 "x")`,
+			AstRecordv("foo", AstInt("1"), AstString("x"))},
+		"bool vector": srcexp{`(#&3"\5")`,
+			AstListv(AstBoolVector([]bool{true, false, true}))},
+	}
+	for k, v := range data {
+		testSExp(t, k, v.src, v.exp)
+	}
+
+	res, _ := Parse(`#s(hash-table size 3 test equal rehash-size 1.5 data (k1 1 (1 2) v2 nil (3)))`)
+	ht := res[0].ToValue().(HashTable)
+	exp := HashTable{Test: "equal", Alist: []Cons{{Car: "k1", Cdr: 1}, {Car: []int{1, 2}, Cdr: "v2"}, {Car: nil, Cdr: []int{3}}}}
+	if !reflect.DeepEqual(ht, exp) {
+		t.Errorf("hash table value: %#v", ht)
+	}
+	res, _ = Parse(`#s(hash-table data (k1 1 "k2" (2)))`)
+	if v := res[0].ToValue(); !reflect.DeepEqual(v, HashTable{Data: map[interface{}]interface{}{"k1": 1, "k2": []int{2}}}) {
+		t.Errorf("hash table value: %#v", v)
+	}
+	res, _ = Parse(`#s(hash-table size 3 test equal rehash-size 1.5 data (k1 1 (1 2) v2 nil (3)))`)
+	if s := res[0].ToSExpString(); s != `#s(hash-table size 3 test equal rehash-size 1.5 data (k1 1 (1 2) v2 nil (3)))` {
+		t.Errorf("hash table string: %s", s)
+	}
+	if h := res[0].(*SExpHashTable); len(h.Props()) != 6 || h.Props()[3].ToSExpString() != "equal" ||
+		len(h.Data()) != 6 || h.Data()[2].ToSExpString() != "(1 2)" {
+		t.Errorf("hash table accessors: %v %v", h.Props(), h.Data())
+	}
+
+	res, _ = Parse(`#s(point 1 2.5)`)
+	if r := res[0].ToValue(); !reflect.DeepEqual(r, Record{Type: "point", Slots: []interface{}{1, 2.5}}) {
+		t.Errorf("record value: %#v", r)
+	}
+	if r := res[0].(*SExpRecord); r.Type().(*SExpSymbol).Name() != "point" || len(r.Slots()) != 2 || r.Slots()[1].ToSExpString() != "2.5" {
+		t.Errorf("record accessors: %v %v", r.Type(), r.Slots())
+	}
+
+	res, _ = Parse(`#&10"\377\2"`)
+	bv := []bool{true, true, true, true, true, true, true, true, false, true}
+	if v := res[0].ToValue(); !reflect.DeepEqual(v, BoolVector(bv)) {
+		t.Errorf("bool-vector value: %#v", v)
+	}
+	if b := res[0].(*SExpBoolVector).Bits(); !reflect.DeepEqual(b, bv) {
+		t.Errorf("bool-vector bits: %v", b)
+	}
+	if s := BoolVectorLiteral(bv); s != `#&10"\377\002"` {
+		t.Errorf("bool-vector literal: %s", s)
+	}
+
+	for _, src := range []string{`#s(hash-table data (1))`, `#s(hash-table test)`, `#s("foo")`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Error should be returned: %s", src)
+		}
+	}
+}
+
+func TestPropertizedString(t *testing.T) {
+	src := `#("foo bar" 0 3 (face bold) 4 7 nil)`
+	testSExp(t, "propertized string", src,
+		AstPropertizedString("foo bar",
+			AstInt("0"), AstInt("3"), AstListv(AstSymbol("face"), AstSymbol("bold")),
+			AstInt("4"), AstInt("7"), AstNil()))
+	res, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Msg)
+	}
+	if s := res[0].ToSExpString(); s != src {
+		t.Errorf("propertized string: %s", s)
+	}
+	if ps := res[0].(*SExpPropertizedString); ps.Text() != "foo bar" || len(ps.Props()) != 6 || ps.Props()[2].ToSExpString() != "(face bold)" {
+		t.Errorf("propertized string accessors: %q %v", ps.Text(), ps.Props())
+	}
+	exp := PropertizedString{Text: "foo bar", Intervals: []TextInterval{
+		{Start: 0, End: 3, Props: map[string]interface{}{"face": Symbol("bold")}},
+		{Start: 4, End: 7, Props: map[string]interface{}{}},
+	}}
+	if v := res[0].ToValueWith(&ValueOptions{PreserveSymbols: true}); !reflect.DeepEqual(v, exp) {
+		t.Errorf("propertized string value: %#v", v)
+	}
+	res, _ = Parse(`(#("a\nb"))`)
+	if v := res[0].ToValue(); !reflect.DeepEqual(v, []interface{}{PropertizedString{Text: "a\nb", Intervals: []TextInterval{}}}) {
+		t.Errorf("propertized string without properties: %#v", v)
+	}
+
+	for _, src := range []string{`#("foo" 0 3)`, `#("foo" 0 a nil)`, `#("foo" 0 3 (face))`, `#("foo" 0 3 face)`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Error should be returned: %s", src)
+		}
+	}
+}
+
+func TestLabelValue(t *testing.T) {
+	res, err := Parse(`(#1=(a b) #1# #2="s" #2#)`)
+	if err != nil {
+		t.Fatal(err.Msg)
+	}
+	if s := res[0].ToSExpString(); s != `(#1=(a b) #1# #2="s" #2#)` {
+		t.Errorf("label string: %s", s)
+	}
+	exp := []interface{}{[]interface{}{"a", "b"}, []interface{}{"a", "b"}, "s", "s"}
+	if v := res[0].ToValue(); !reflect.DeepEqual(v, exp) {
+		t.Errorf("label value: %#v", v)
+	}
+	if v := res[0].ToValue().([]interface{}); reflect.ValueOf(v[0]).Pointer() != reflect.ValueOf(v[1]).Pointer() {
+		t.Error("reference does not share the value of the label")
+	}
+
+	// each level refers to the previous one twice
+	src := "#0=(x)"
+	for i := 1; i <= 40; i++ {
+		src = fmt.Sprintf("#%d=(%s #%d#)", i, src, i-1)
+	}
+	deep, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Msg)
+	}
+	for v, i := deep[0].ToValue(), 0; i < 40; i++ {
+		l := v.([]interface{})
+		if reflect.ValueOf(l[0]).Pointer() != reflect.ValueOf(l[1]).Pointer() {
+			t.Fatalf("level %d: reference does not share the value of the label", 40-i)
+		}
+		v = l[0]
+	}
+	if err := CheckCycles(res); err != nil {
+		t.Errorf("shared structure is not a cycle: %v", err)
+	}
+	ref := res[0].(*SExpList).elements[1].(*SExpLabelRef)
+	if ref.target != res[0].(*SExpList).elements[0] {
+		t.Error("reference is not linked to the label")
+	}
+
+	res, err = Parse(`#1=(a #2=[b #1#] . #2#)`)
+	if err != nil {
+		t.Fatal(err.Msg)
+	}
+	if err := CheckCycles(res); err == nil || err.Error() != "cyclic structure via #1#" {
+		t.Errorf("cycle is not detected: %v", err)
+	}
+	if v := res[0].ToValueWith(&ValueOptions{PreserveCons: true}); !reflect.DeepEqual(v,
+		Cons{Car: "a", Cdr: Cons{Car: []interface{}{"b", nil}, Cdr: []interface{}{"b", nil}}}) {
+		t.Errorf("cyclic value: %#v", v)
+	}
+
+	if _, err := Parse(`(#1# #1=a)`); err == nil || err.Msg != "Undefined label: #1#" {
+		t.Errorf("undefined label: %v", err)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	src := "(defun foo ()\n  \"doc \\\"q\\\"\"\n  '(1 . 2.5))"
+	res, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Msg)
+	}
+	pos := func(off, line, col int) Position { return Position{Offset: Pos(off), Line: line, Col: col} }
+	lst := res[0].(*SExpList)
+	data := []struct {
+		node SExp
+		span Span
+	}{
+		{lst, Span{pos(0, 1, 1), pos(41, 3, 14)}},
+		{lst.elements[1], Span{pos(7, 1, 8), pos(10, 1, 11)}},
+		{lst.elements[2], Span{pos(11, 1, 12), pos(13, 1, 14)}},
+		{lst.elements[3], Span{pos(16, 2, 3), pos(27, 2, 14)}},
+		{lst.elements[4], Span{pos(30, 3, 3), pos(40, 3, 13)}},
+		{lst.elements[4].(*SExpQuoted).sexp, Span{pos(31, 3, 4), pos(40, 3, 13)}},
+		{lst.elements[4].(*SExpQuoted).sexp.(*SExpCons).cdr, Span{pos(36, 3, 9), pos(39, 3, 12)}},
+	}
+	for i, d := range data {
+		if d.node.Span() != d.span {
+			t.Errorf("%d: wrong span of %s: %v (expected %v)", i, d.node.ToSExpString(), d.node.Span(), d.span)
+		}
+	}
+}
+
+func TestParseRecover(t *testing.T) {
+	res, errs := ParseRecover("(a ] b)\n(c ?\\C d)\n#s() (e")
+	if len(res) != 3 || res[0].ToSExpString() != "(a b)" || res[1].ToSExpString() != "(c d)" {
+		t.Errorf("wrong recovered result: %v", res)
+	}
+	exp := []string{
+		`1:4: syntax error: unexpected "]"`,
+		`2:4: Invalid character literal: Invalid escape character syntax: \C`,
+		`3:4: syntax error: unexpected ")"`,
+		`3:1: Invalid record: no type`,
+		`3:8: syntax error: unexpected $end`,
+	}
+	if len(errs) != len(exp) {
+		t.Fatalf("wrong number of errors: %v", errs)
+	}
+	for i, e := range errs {
+		if e.Error() != exp[i] {
+			t.Errorf("%d: wrong error: %s (expected %s)", i, e.Error(), exp[i])
+		}
+	}
+
+	_, err := Parse("(a ] b)\n(c ?\\C d)")
+	if err == nil || err.Error() != `1:4: syntax error: unexpected "]"` {
+		t.Errorf("Parse should return the first error: %v", err)
+	}
+	if _, errs := ParseRecover("(#1#)"); len(errs) != 1 || errs[0].Col != 2 {
+		t.Errorf("wrong undefined label error: %v", errs)
+	}
+}
+
+func TestWalkAndQuery(t *testing.T) {
+	src := `(require 'cl-lib)
+(defun foo (a) (bar a))
+(defvar baz 1)
+(progn (defun hoge () 'x) (require 'subr-x))
+'(defun)`
+	sexps, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var pre, post []string
+	Walk(sexps[1], func(s SExp) bool {
+		pre = append(pre, s.ToSExpString())
+		_, isList := s.(*SExpList)
+		return !isList || s == sexps[1]
+	}, func(s SExp) {
+		post = append(post, s.ToSExpString())
+	})
+	if len(pre) != 5 || pre[1] != "defun" || pre[4] != "(bar a)" {
+		t.Errorf("Walk pre: %v", pre)
+	}
+	if len(post) != 3 || post[2] != "(defun foo (a) (bar a))" {
+		t.Errorf("Walk post: %v", post)
+	}
+
+	testQuery := func(pattern string, expected ...string) {
+		res, err := Query(sexps, pattern)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var names []string
+		for _, r := range res {
+			names = append(names, r.ToSExpString())
+		}
+		if len(names) != len(expected) {
+			t.Errorf("Query %s: %v", pattern, names)
+			return
+		}
+		for i := range names {
+			if names[i] != expected[i] {
+				t.Errorf("Query %s: %v", pattern, names)
+			}
+		}
+	}
+	testQuery("(defun _ _*)", "(defun foo (a) (bar a))", "(defun hoge nil 'x)")
+	testQuery("(require '_)", "(require 'cl-lib)", "(require 'subr-x)")
+	testQuery("(_* 1)", "(defvar baz 1)")
+	testQuery("(defun)", "(defun)")
+	testQuery("(nothing _)")
+
+	defuns, _ := Query(sexps, "(defun _ _*)")
+	name := defuns[0].(*SExpList).Elements()[1].(*SExpSymbol).Name()
+	if name != "foo" {
+		t.Errorf("Name: %s", name)
+	}
+	if _, err := Query(sexps, "a b"); err == nil {
+		t.Errorf("Expected an error for two patterns")
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	src := `(sym "plain" "esc\"aped" ?a 12 1.5 :key #s(rec x))`
+	buf := []byte(src)
+	sexps, err := ParseBytes(buf)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for i := range buf {
+		buf[i] = 'X' // reuse the buffer
+	}
+	if res := sexps[0].ToSExpString(); res != src {
+		t.Errorf("Broken by the buffer reuse: %s", res)
+	}
+
+	buf = []byte("(a\n  ]b)")
+	_, err = ParseBytes(buf)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	copy(buf, "XXXXXXXX")
+	if err.Text != "\n  ]b)\n  ^" {
+		t.Errorf("Broken error text: %q", err.Text)
+	}
+	if sexps, err := ParseBytes(nil); err != nil || len(sexps) != 0 {
+		t.Errorf("Empty bytes: %v %v", sexps, err)
+	}
+}
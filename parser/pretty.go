@@ -0,0 +1,249 @@
+package parser
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PrettyOptions controls PrettyPrint. The nil options mean the default.
+type PrettyOptions struct {
+	Width  int            // line width limit (80 when zero)
+	Indent map[string]int // indent specs added to the defaults, such as "my-with-foo": 1
+}
+
+// defaultIndentSpecs is the indent specs, the number of the distinguished
+// arguments like the lisp-indent-function property of Emacs. The
+// distinguished arguments are indented by 4 spaces and the body by 2.
+// It is never modified, so that PrettyPrint can run concurrently.
+var defaultIndentSpecs = map[string]int{
+	"defun": 2, "defmacro": 2, "defsubst": 2, "cl-defun": 2, "cl-defmacro": 2,
+	"lambda": 1, "let": 1, "let*": 1, "if-let": 2, "when-let": 1,
+	"if": 2, "when": 1, "unless": 1, "while": 1, "dolist": 1, "dotimes": 1,
+	"condition-case": 2, "unwind-protect": 1, "catch": 1, "pcase": 1,
+	"progn": 0, "prog1": 1, "prog2": 2, "save-excursion": 0, "save-restriction": 0,
+	"save-match-data": 0, "with-current-buffer": 1, "with-temp-buffer": 0,
+	"with-eval-after-load": 1, "eval-when-compile": 0, "eval-and-compile": 0,
+}
+
+// PrettyPrint prints the S-expression in multiple lines with the Emacs
+// Lisp indentation, when it does not fit in the width:
+//
+//	(defun foo (a b)
+//	  (if (bar a)
+//	      (baz b)
+//	    (message "args: %s %s"
+//	             a b)))
+//
+// The special forms have the body indented by 2 spaces, the function
+// arguments are aligned to the first one, and the quoted lists are
+// aligned as data.
+func PrettyPrint(s SExp, o *PrettyOptions) string {
+	p := &prettyPrinter{width: 80, specs: defaultIndentSpecs}
+	if o != nil {
+		if o.Width > 0 {
+			p.width = o.Width
+		}
+		if len(o.Indent) > 0 {
+			p.specs = DefaultIndentSpecs()
+			for k, v := range o.Indent {
+				p.specs[k] = v
+			}
+		}
+	}
+	p.print(s, false)
+	return p.buf.String()
+}
+
+// DefaultIndentSpecs returns a copy of the default indent specs, which
+// PrettyOptions.Indent is added to.
+func DefaultIndentSpecs() map[string]int {
+	specs := make(map[string]int, len(defaultIndentSpecs))
+	for k, v := range defaultIndentSpecs {
+		specs[k] = v
+	}
+	return specs
+}
+
+type prettyPrinter struct {
+	buf   bytes.Buffer
+	col   int
+	width int
+	specs map[string]int
+}
+
+func (p *prettyPrinter) write(s string) {
+	p.buf.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		p.col = utf8.RuneCountInString(s[i+1:])
+	} else {
+		p.col += utf8.RuneCountInString(s)
+	}
+}
+
+func (p *prettyPrinter) newline(col int) {
+	p.write("\n" + strings.Repeat(" ", col))
+}
+
+// spec returns the number of the distinguished arguments of the form,
+// and whether they are all put on the first line like defun.
+func (p *prettyPrinter) spec(name string) (int, bool, bool) {
+	def := strings.HasPrefix(name, "def") || strings.HasPrefix(name, "cl-def") || name == "lambda"
+	if n, ok := p.specs[name]; ok {
+		return n, def, true
+	}
+	if def {
+		return 1, true, true // ex: defvar, defcustom
+	}
+	return 0, false, false
+}
+
+// flatWidth returns the width of the S-expression in one line. It stops
+// measuring the lists as soon as the width is over the limit, not to
+// make the strings of the whole subtrees at every level.
+func flatWidth(s SExp, limit int) int {
+	seq := func(open string, elements []SExp, last SExp, close string) int {
+		w := len(open) + len(close)
+		for i, e := range elements {
+			if i > 0 {
+				w++
+			}
+			if w > limit {
+				return w
+			}
+			w += flatWidth(e, limit-w)
+		}
+		if last != nil && w <= limit {
+			w += len(" . ") + flatWidth(last, limit-w-len(" . "))
+		}
+		return w
+	}
+	prefixed := func(prefix string, inner SExp) int {
+		return len(prefix) + flatWidth(inner, limit-len(prefix))
+	}
+	switch s := s.(type) {
+	case *SExpList:
+		return seq("(", s.elements, nil, ")")
+	case *SExpListDot:
+		return seq("(", s.elements, s.last, ")")
+	case *SExpCons:
+		return seq("(", []SExp{s.car}, s.cdr, ")")
+	case *SExpVector:
+		return seq("[", s.elements, nil, "]")
+	case *SExpRecord:
+		return seq("#s(", s.elements, nil, ")")
+	case *SExpQuoted:
+		if s.function {
+			return prefixed("#'", s.sexp)
+		}
+		return prefixed("'", s.sexp)
+	case *SExpQuasiQuoted:
+		return prefixed("`", s.sexp)
+	case *SExpUnquote:
+		if s.splice {
+			return prefixed(",@", s.sexp)
+		}
+		return prefixed(",", s.sexp)
+	case *SExpLabel:
+		return prefixed("#"+strconv.Itoa(s.label)+"=", s.sexp)
+	}
+	return utf8.RuneCountInString(s.ToSExpString())
+}
+
+func (p *prettyPrinter) print(s SExp, data bool) {
+	if p.col+flatWidth(s, p.width-p.col) <= p.width {
+		p.write(s.ToSExpString())
+		return
+	}
+	switch s := s.(type) {
+	case *SExpList:
+		p.printList("(", s.elements, nil, ")", data)
+	case *SExpListDot:
+		p.printList("(", s.elements, s.last, ")", data)
+	case *SExpCons:
+		p.printList("(", []SExp{s.car}, s.cdr, ")", data)
+	case *SExpVector:
+		p.printList("[", s.elements, nil, "]", true)
+	case *SExpRecord:
+		p.printList("#s(", s.elements, nil, ")", true)
+	case *SExpQuoted:
+		if s.function {
+			p.write("#'")
+			p.print(s.sexp, false)
+		} else {
+			p.write("'")
+			p.print(s.sexp, true)
+		}
+	case *SExpQuasiQuoted:
+		p.write("`")
+		p.print(s.sexp, data)
+	case *SExpUnquote:
+		if s.splice {
+			p.write(",@")
+		} else {
+			p.write(",")
+		}
+		p.print(s.sexp, false)
+	case *SExpLabel:
+		p.write("#" + strconv.Itoa(s.label) + "=")
+		p.print(s.sexp, data)
+	default:
+		p.write(s.ToSExpString())
+	}
+}
+
+func (p *prettyPrinter) printList(open string, elements []SExp, last SExp, close string, data bool) {
+	start := p.col
+	p.write(open)
+	if len(elements) == 0 {
+		p.write(close)
+		return
+	}
+	head, isSymbol := elements[0].(*SExpSymbol)
+	p.print(elements[0], data)
+	rest := elements[1:]
+	n, def, special := 0, false, false
+	if isSymbol && !data {
+		n, def, special = p.spec(head.literal)
+	}
+	switch {
+	case !isSymbol || data:
+		// data: aligned to the first element
+		for _, e := range rest {
+			p.newline(start + len(open))
+			p.print(e, data)
+		}
+	case special:
+		if n > len(rest) {
+			n = len(rest)
+		}
+		for i, e := range rest[:n] {
+			if i == 0 || def {
+				p.write(" ")
+			} else {
+				p.newline(start + 4)
+			}
+			p.print(e, false)
+		}
+		for _, e := range rest[n:] {
+			p.newline(start + 2)
+			p.print(e, false)
+		}
+	case len(rest) > 0:
+		// function call: the arguments are aligned to the first one
+		p.write(" ")
+		argCol := p.col
+		p.print(rest[0], false)
+		for _, e := range rest[1:] {
+			p.newline(argCol)
+			p.print(e, false)
+		}
+	}
+	if last != nil {
+		p.newline(start + len(open))
+		p.write(". ")
+		p.print(last, data)
+	}
+	p.write(close)
+}
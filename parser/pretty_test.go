@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func testPretty(t *testing.T, src string, o *PrettyOptions, expected string) {
+	sexps, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(sexps) != 1 {
+		t.Fatalf("Expected one sexp: %d", len(sexps))
+	}
+	if res := PrettyPrint(sexps[0], o); res != expected {
+		t.Errorf("Not equal:\n%s\n--- expected ---\n%s", res, expected)
+	}
+}
+
+func TestPrettyPrint(t *testing.T) {
+	testPretty(t, `(foo 1 "a" b)`, nil, `(foo 1 "a" b)`)
+	testPretty(t,
+		`(defun foo (a b) "Doc." (let ((x (+ a 1)) (y (* b 2))) (if (> x y) (message "x: %s" x) (message "y: %s" y) y)))`,
+		&PrettyOptions{Width: 30},
+		`(defun foo (a b)
+  "Doc."
+  (let ((x (+ a 1))
+        (y (* b 2)))
+    (if (> x y)
+        (message "x: %s" x)
+      (message "y: %s" y)
+      y)))`)
+	testPretty(t,
+		`(setq list '(alpha beta gamma delta) vec [one two three four])`,
+		&PrettyOptions{Width: 24},
+		`(setq list
+      '(alpha
+        beta
+        gamma
+        delta)
+      vec
+      [one
+       two
+       three
+       four])`)
+	testPretty(t,
+		`(condition-case err (progn (foo) (bar)) (error (message "%s" err)))`,
+		&PrettyOptions{Width: 30},
+		`(condition-case err
+    (progn (foo) (bar))
+  (error (message "%s" err)))`)
+	testPretty(t,
+		`(my-with-foo (a b c) (body-one 1) (body-two 2))`,
+		&PrettyOptions{Width: 20, Indent: map[string]int{"my-with-foo": 1}},
+		`(my-with-foo (a b c)
+  (body-one 1)
+  (body-two 2))`)
+	testPretty(t,
+		`(defvar my-long-variable (list 1 2 3) "Docstring.")`,
+		&PrettyOptions{Width: 30},
+		`(defvar my-long-variable
+  (list 1 2 3)
+  "Docstring.")`)
+}
+
+func TestFlatWidth(t *testing.T) {
+	src := `(a "é" (b . c) (d e . f) [1 2.5] #s(point 1 2) '(x) #'car ` + "`(,a ,@b)" + ` #1=(g) #1# #s(hash-table data (k v)) #("s" 0 1 (face bold)) ?\C-x)`
+	sexps, err := Parse(src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	Inspect(sexps[0], func(s SExp) bool {
+		if w, exp := flatWidth(s, 1000), utf8.RuneCountInString(s.ToSExpString()); w != exp {
+			t.Errorf("flat width of %s: %d (expected %d)", s.ToSExpString(), w, exp)
+		}
+		return true
+	})
+	if w := flatWidth(sexps[0], 10); w <= 10 {
+		t.Errorf("flat width over the limit: %d", w)
+	}
+}
+
+func TestDefaultIndentSpecs(t *testing.T) {
+	specs := DefaultIndentSpecs()
+	specs["foo"] = 0
+	if _, ok := DefaultIndentSpecs()["foo"]; ok {
+		t.Error("DefaultIndentSpecs should return a copy")
+	}
+	testPretty(t, `(foo (a b c) (body-one 1) (body-two 2))`, &PrettyOptions{Width: 20},
+		`(foo (a b c)
+     (body-one 1)
+     (body-two 2))`)
+}
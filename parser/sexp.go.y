@@ -9,6 +9,7 @@ type Token struct {
     token   int
     literal string
     pos     Pos
+    end     Pos
 }
 
 %}
@@ -20,8 +21,10 @@ type Token struct {
 }
 
 %type   <expr>          sexp val nil cons list vector symbol character string quoted unquote
-%type   <seq>           target sexp_seq
-%token  <token>         INTEGER FLOAT SYMBOL CHARACTER STRING
+%type   <expr>          record bool_vector propertized_string label label_ref
+%type   <seq>           sexp_seq
+%token  <token>         INTEGER FLOAT SYMBOL CHARACTER STRING RECORD BOOL_VECTOR
+%token  <token>         LABEL LABEL_REF
 
 %%
 
@@ -30,17 +33,25 @@ target
       {
           yylex.(*Lexer).result = []SExp{}
       }
-      | sexp_seq
-      {
-          $$ = $1
-          yylex.(*Lexer).result = $$
-      }
+      | top_seq
 
 void  :
 
+top_seq
+      : top_sexp | top_seq top_sexp
+
+top_sexp
+      : sexp
+      {
+          // collect each one to keep the results before an unrecoverable error
+          yylex.(*Lexer).result = append(yylex.(*Lexer).result, $1)
+      }
+      | error
+
 sexp
       : nil | val | cons | list | vector | character
-      | symbol | string | quoted | unquote
+      | symbol | string | quoted | unquote | record | bool_vector
+      | propertized_string | label | label_ref
 
 sexp_seq
       : sexp
@@ -51,117 +62,181 @@ sexp_seq
       {
           $$ = append($1, $2)
       }
+      | error
+      {
+          $$ = []SExp{} // skip the broken tokens to recover
+      }
+      | sexp_seq error
+      {
+          $$ = $1
+      }
 
 nil
       : "(" ")"
       {
-          $$ = &SExpNil{}
+          $$ = yylex.(*Lexer).setSpan(&SExpNil{}, $<token>1.pos, $<token>2.end)
       }
 
 cons
       : "(" sexp_seq "." sexp ")"
       {
           sq := $2
-          if len(sq) == 1 {
+          if len(sq) == 0 {
+              $$ = &SExpList{elements: []SExp{$4}}
+          } else if len(sq) == 1 {
               $$ = &SExpCons{car:sq[0], cdr:$4}
           } else {
               $$ = &SExpListDot{elements:sq, last:$4}
           }
+          $$ = yylex.(*Lexer).setSpan($$, $<token>1.pos, $<token>5.end)
       }
 
 list
       : "(" sexp_seq ")"
       {
-          $$ = &SExpList{elements: $2}
+          $$ = yylex.(*Lexer).setSpan(&SExpList{elements: $2}, $<token>1.pos, $<token>3.end)
       }
 
 vector
       : "[" sexp_seq "]"
       {
-          $$ = &SExpVector{elements: $2}
+          $$ = yylex.(*Lexer).setSpan(&SExpVector{elements: $2}, $<token>1.pos, $<token>3.end)
       }
       | "[" "]"
       {
-          $$ = &SExpVector{elements: []SExp{}}
+          $$ = yylex.(*Lexer).setSpan(&SExpVector{elements: []SExp{}}, $<token>1.pos, $<token>2.end)
+      }
+
+record
+      : RECORD "(" sexp_seq ")"
+      {
+          $$ = yylex.(*Lexer).makeRecord($3, $<token>1.pos, $<token>4.end)
+      }
+
+propertized_string
+      : "#" "(" STRING ")"
+      {
+          $$ = yylex.(*Lexer).makePropertizedString($3.literal, nil, $<token>1.pos, $<token>4.end)
+      }
+      | "#" "(" STRING sexp_seq ")"
+      {
+          $$ = yylex.(*Lexer).makePropertizedString($3.literal, $4, $<token>1.pos, $<token>5.end)
+      }
+
+label
+      : LABEL sexp
+      {
+          $$ = yylex.(*Lexer).setSpan(&SExpLabel{label: labelNumber($1.literal), sexp: $2}, $<token>1.pos, $2.Span().End.Offset)
+      }
+
+label_ref
+      : LABEL_REF
+      {
+          $$ = yylex.(*Lexer).setSpan(&SExpLabelRef{label: labelNumber($1.literal)}, $<token>1.pos, $<token>1.end)
+      }
+
+bool_vector
+      : BOOL_VECTOR
+      {
+          bits, _ := parseBoolVector($1.literal)
+          $$ = yylex.(*Lexer).setSpan(&SExpBoolVector{bits: bits}, $<token>1.pos, $<token>1.end)
       }
 
 val
       : INTEGER
       {
-          $$ = &SExpInt{literal: $1.literal}
+          $$ = yylex.(*Lexer).setSpan(&SExpInt{literal: $1.literal}, $<token>1.pos, $<token>1.end)
       }
       | FLOAT
       {
-          $$ = &SExpFloat{literal: $1.literal}
+          $$ = yylex.(*Lexer).setSpan(&SExpFloat{literal: $1.literal}, $<token>1.pos, $<token>1.end)
       }
 
 quoted
       : "'" sexp
       {
-          $$ = &SExpQuoted{sexp: $2}
+          $$ = yylex.(*Lexer).setSpan(&SExpQuoted{sexp: $2}, $<token>1.pos, $2.Span().End.Offset)
       }
       | "`" sexp
       {
-          $$ = &SExpQuasiQuoted{sexp: $2}
+          $$ = yylex.(*Lexer).setSpan(&SExpQuasiQuoted{sexp: $2}, $<token>1.pos, $2.Span().End.Offset)
       }
       | "#" "'" sexp
       {
-          $$ = &SExpQuoted{sexp: $3, function: true}
+          $$ = yylex.(*Lexer).setSpan(&SExpQuoted{sexp: $3, function: true}, $<token>1.pos, $3.Span().End.Offset)
       }
 
 unquote
       : "," sexp
       {
-          $$ = &SExpUnquote{sexp: $2, splice: false}
+          $$ = yylex.(*Lexer).setSpan(&SExpUnquote{sexp: $2, splice: false}, $<token>1.pos, $2.Span().End.Offset)
       }
       | "," "@" sexp
       {
-          $$ = &SExpUnquote{sexp: $3, splice: true}
+          $$ = yylex.(*Lexer).setSpan(&SExpUnquote{sexp: $3, splice: true}, $<token>1.pos, $3.Span().End.Offset)
       }
 
 symbol
       : SYMBOL
       {
-          $$ = AstSymbol($1.literal)
+          $$ = yylex.(*Lexer).setSpan(AstSymbol($1.literal), $<token>1.pos, $<token>1.end)
       }
 
 character
       : CHARACTER
       {
-          $$ = &SExpChar{literal: $1.literal}
+          $$ = yylex.(*Lexer).setSpan(&SExpChar{literal: $1.literal}, $<token>1.pos, $<token>1.end)
       }
 
 string
       : STRING
       {
-          $$ = &SExpString{literal: $1.literal}
+          $$ = yylex.(*Lexer).setSpan(&SExpString{literal: $1.literal}, $<token>1.pos, $<token>1.end)
       }
 
 
 %%
 
+func init() {
+	yyErrorVerbose = true
+}
+
 func (l *Lexer) Error(e string) {
-	l.error = &Error{
-        Msg: e, Pos: l.lastPos,
-        Line: l.lineNumber(),
-        Col: l.columnNumber(),
-        Text: l.errorLineText(),
-    }
+	l.errorAt(l.lastPos, e)
+}
+
+// errorAt records an error at the offset position.
+func (l *Lexer) errorAt(pos Pos, e string) {
+	err := &Error{
+		Msg: e, Pos: pos,
+		Line: l.lineNumber(pos),
+		Col: l.columnNumber(pos),
+		Text: l.own(l.errorLineText(pos)),
+	}
+	if l.error == nil {
+		l.error = err // the first error
+	}
+	l.errors = append(l.errors, err)
 }
 
 func (l *Lexer) Lex(lval *yySymType) int {
-	item := l.nextItem()
+	var item item
 	for {
+		item = l.nextItem()
 		if item.itype == itemEOF {
 			return 0
 		}
+		if item.itype == itemError {
+			l.Error(item.val) // skip the invalid token
+			continue
+		}
 		if item.itype != itemSpace && item.itype != itemComment {
 			break
 		}
-		item = l.nextItem()
 	}
 	//fmt.Printf("]LEX: %d - %v\n", item.itype, item.val)
 	tok := -1
+	end := item.pos + Pos(len(item.val))
 	switch {
 	case item.itype == itemInteger:
 		tok = INTEGER
@@ -169,28 +244,132 @@ func (l *Lexer) Lex(lval *yySymType) int {
 		tok = FLOAT
 	case item.itype == itemSymbol:
 		tok = SYMBOL
+		item.val = unescapeSymbol(item.val)
 	case item.itype == itemString:
 		tok = STRING
-        item.val = item.val[1:len(item.val)-1]
+		item.val = item.text
+	case item.itype == itemRecord:
+		tok = RECORD
+	case item.itype == itemBoolVector:
+		tok = BOOL_VECTOR
+	case item.itype == itemLabel:
+		tok = LABEL
+	case item.itype == itemLabelRef:
+		tok = LABEL_REF
 	case item.itype == itemCharLit:
 		tok = CHARACTER
-        item.val = item.val[1:len(item.val)]
+		item.val = item.val[1:len(item.val)]
 	default:
 		r, _ := utf8.DecodeRuneInString(item.val)
 		tok = int(r)
 	}
-	lval.token = Token{token: tok, literal: item.val, pos: item.pos}
+	lval.token = Token{token: tok, literal: l.own(item.val), pos: item.pos, end: end}
 	return tok
 }
 
-func Parse(str string) ([]SExp, *Error) {
-    //yyErrorVerbose = true
-	l := &Lexer{}
+// setSpan sets the source range of the node.
+func (l *Lexer) setSpan(s SExp, start, end Pos) SExp {
+	s.(interface{ setSpan(Span) }).setSpan(Span{Start: l.position(start), End: l.position(end)})
+	return s
+}
+
+// makeRecord builds a hash table or a record from the elements of #s(...).
+func (l *Lexer) makeRecord(elements []SExp, start, end Pos) SExp {
+	if len(elements) == 0 {
+		l.errorAt(start, "Invalid record: no type")
+		return l.setSpan(&SExpRecord{elements: []SExp{AstNil()}}, start, end)
+	}
+	sym, ok := elements[0].(*SExpSymbol)
+	if !ok {
+		l.errorAt(elements[0].Span().Start.Offset, "Invalid record type: "+elements[0].ToSExpString())
+		return l.setSpan(&SExpRecord{elements: elements}, start, end)
+	}
+	if sym.literal != "hash-table" {
+		return l.setSpan(&SExpRecord{elements: elements}, start, end)
+	}
+	props := elements[1:]
+	if len(props)%2 != 0 {
+		l.errorAt(start, "Invalid hash table: odd number of properties")
+	}
+	ret := &SExpHashTable{}
+	for i := 0; i+1 < len(props); i += 2 {
+		if key, ok := props[i].(*SExpSymbol); ok && key.literal == "data" {
+			switch d := props[i+1].(type) {
+			case *SExpList:
+				ret.data = d.elements
+				if len(ret.data)%2 != 0 {
+					l.errorAt(d.Span().Start.Offset, "Invalid hash table data: odd number of elements")
+				}
+			case *SExpNil:
+			default:
+				l.errorAt(d.Span().Start.Offset, "Invalid hash table data: "+d.ToSExpString())
+			}
+			continue
+		}
+		ret.props = append(ret.props, props[i], props[i+1])
+	}
+	return l.setSpan(ret, start, end)
+}
+
+// makePropertizedString builds a propertized string from the text and
+// the following intervals, "start end plist ...".
+func (l *Lexer) makePropertizedString(text string, props []SExp, start, end Pos) SExp {
+	if len(props)%3 != 0 {
+		l.errorAt(start, "Invalid text properties: the intervals should be triples")
+	}
+	for i := 0; i+2 < len(props); i += 3 {
+		_, ok1 := props[i].(*SExpInt)
+		_, ok2 := props[i+1].(*SExpInt)
+		if !ok1 || !ok2 {
+			l.errorAt(props[i].Span().Start.Offset, "Invalid text properties: the interval should be integers")
+		}
+		switch pl := props[i+2].(type) {
+		case *SExpNil:
+		case *SExpList:
+			if len(pl.elements)%2 != 0 {
+				l.errorAt(pl.Span().Start.Offset, "Invalid text properties: odd number of the plist")
+			}
+		default:
+			l.errorAt(pl.Span().Start.Offset, "Invalid text properties: "+pl.ToSExpString())
+		}
+	}
+	return l.setSpan(&SExpPropertizedString{text: text, props: props}, start, end)
+}
+
+func (l *Lexer) parse(str string) {
 	l.Init(str)
 	yyParse(l)
-    if l.error == nil {
-        return l.result, nil
-    } else {
-        return nil, l.error
-    }
+	l.drain()
+	resolveLabels(l.result, func(s SExp, msg string) {
+		l.errorAt(s.Span().Start.Offset, msg)
+	})
+}
+
+func Parse(str string) ([]SExp, *Error) {
+	l := &Lexer{}
+	l.parse(str)
+	if l.error == nil {
+		return l.result, nil
+	} else {
+		return nil, l.error
+	}
+}
+
+// ParseBytes parses the source without copying it into a string. The
+// result does not share the memory with b, so b can be reused after that.
+func ParseBytes(b []byte) ([]SExp, *Error) {
+	l := &Lexer{shared: true}
+	l.parse(bytesToString(b))
+	if l.error == nil {
+		return l.result, nil
+	}
+	return nil, l.error
+}
+
+// ParseRecover parses the source as much as possible, skipping the broken
+// parts, and returns the result with all of the errors.
+func ParseRecover(str string) ([]SExp, []*Error) {
+	l := &Lexer{}
+	l.parse(str)
+	return l.result, l.errors
 }
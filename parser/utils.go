@@ -3,6 +3,10 @@ package parser
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 	"unicode/utf8"
 )
 
@@ -35,6 +39,8 @@ func StringLiteral(content string) string {
 			case '\t':
 				buf.WriteByte('\\')
 				buf.WriteByte('t')
+			default:
+				buf.WriteString(fmt.Sprintf("\\%03o", b))
 			}
 			i++
 			start = i
@@ -45,7 +51,7 @@ func StringLiteral(content string) string {
 			if start < i {
 				buf.WriteString(content[start:i])
 			}
-			buf.WriteString(`\ufffd`)
+			buf.WriteString(fmt.Sprintf("\\%03o", content[i])) // raw byte
 			i += size
 			start = i
 			continue
@@ -54,7 +60,7 @@ func StringLiteral(content string) string {
 			if start < i {
 				buf.WriteString(content[start:i])
 			}
-			buf.WriteString(fmt.Sprintf("\\U000%x", c))
+			buf.WriteString(fmt.Sprintf("\\U%08x", c))
 			i += size
 			start = i
 			continue
@@ -68,43 +74,212 @@ func StringLiteral(content string) string {
 	return buf.String()
 }
 
+// SymbolLiteralChecked is SymbolLiteral for the names of valid UTF-8,
+// because the symbols have no escapes for the raw bytes.
+func SymbolLiteralChecked(symbolName string) (string, error) {
+	if !utf8.ValidString(symbolName) {
+		return "", fmt.Errorf("symbol name is not valid UTF-8: %q", symbolName)
+	}
+	return SymbolLiteral(symbolName), nil
+}
+
 // convert symbol literal
+// The invalid bytes are written as they are, which the lexer reads back.
 func SymbolLiteral(symbolName string) string {
+	if symbolName == "" {
+		return "##" // the interned empty symbol
+	}
 	buf := bytes.Buffer{}
-	start := 0
+	if symbolName == "." || looksLikeNumber(symbolName) {
+		buf.WriteByte('\\')
+	}
 	for i := 0; i < len(symbolName); {
-		if b := symbolName[i]; b < utf8.RuneSelf {
-			if 0x20 <= b && b != '\\' && b != '"' && b != '?' && b != ' ' {
-				i++
-				continue
-			}
-			if start < i {
-				buf.WriteString(symbolName[start:i])
-			}
-			switch b {
-			case '\\', '"', '?', ' ':
-				buf.WriteByte('\\')
-				buf.WriteByte(b)
-			default:
-			}
+		r, size := utf8.DecodeRuneInString(symbolName[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.WriteByte(symbolName[i]) // raw byte
 			i++
-			start = i
 			continue
 		}
-		c, size := utf8.DecodeRuneInString(symbolName[i:])
-		if c == utf8.RuneError && size == 1 {
-			if start < i {
-				buf.WriteString(symbolName[start:i])
-			}
-			buf.WriteString(`\ufffd`)
-			i += size
-			start = i
-			continue
+		switch {
+		case r == '\\':
+			buf.WriteByte('\\')
+		case i == 0 && !isSymbolHead(r) && !isDigit(r):
+			buf.WriteByte('\\')
+		case i > 0 && !isSymbolRest(r):
+			buf.WriteByte('\\')
 		}
+		buf.WriteRune(r)
 		i += size
 	}
-	if start < len(symbolName) {
-		buf.WriteString(symbolName[start:])
+	return buf.String()
+}
+
+// looksLikeNumber reports whether the lexer reads the symbol name as a number.
+func looksLikeNumber(symbolName string) bool {
+	if strings.HasSuffix(symbolName, "e+INF") || strings.HasSuffix(symbolName, "e+NaN") {
+		return true
+	}
+	_, err := strconv.ParseFloat(strings.TrimSuffix(symbolName, "."), 64)
+	return err == nil
+}
+
+// unescapeSymbol returns the symbol name of a symbol literal.
+func unescapeSymbol(lit string) string {
+	if lit == "##" {
+		return ""
+	}
+	if strings.IndexByte(lit, '\\') < 0 {
+		return lit
+	}
+	buf := strings.Builder{}
+	escaped := false
+	// by bytes, to keep the raw bytes
+	for i := 0; i < len(lit); i++ {
+		if lit[i] == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		buf.WriteByte(lit[i])
+	}
+	return buf.String()
+}
+
+// convert float literal
+// The result is always read as a float by Emacs, including NaN and infinities.
+func FloatLiteral(f float64, bitSize int) string {
+	switch {
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-0.0e+NaN"
+		}
+		return "0.0e+NaN"
+	case math.IsInf(f, 1):
+		return "1.0e+INF"
+	case math.IsInf(f, -1):
+		return "-1.0e+INF"
+	}
+	ret := strconv.FormatFloat(f, 'g', -1, bitSize)
+	if !strings.ContainsAny(ret, ".e") {
+		ret += ".0" // "2" is read as an integer
+	}
+	return ret
+}
+
+// parse float literal, including the Emacs notation of NaN and infinities
+func parseFloatLiteral(lit string) float64 {
+	neg := strings.HasPrefix(lit, "-")
+	switch {
+	case strings.HasSuffix(lit, "e+INF"):
+		if neg {
+			return math.Inf(-1)
+		}
+		return math.Inf(1)
+	case strings.HasSuffix(lit, "e+NaN"):
+		if neg {
+			return math.Copysign(math.NaN(), -1)
+		}
+		return math.NaN()
+	}
+	f, _ := strconv.ParseFloat(lit, 64)
+	return f
+}
+
+// convert integer literal in the radix notation
+// ex: IntLiteral(255, 16) -> #xff
+func IntLiteral(v int64, radix int) string {
+	digits := strconv.FormatInt(v, radix)
+	switch radix {
+	case 10:
+		return digits
+	case 16:
+		return "#x" + digits
+	case 8:
+		return "#o" + digits
+	case 2:
+		return "#b" + digits
+	}
+	return "#" + strconv.Itoa(radix) + "r" + digits
+}
+
+// radixOf returns the radix and the rest digits of an integer literal.
+func radixOf(lit string) (int, string) {
+	if !strings.HasPrefix(lit, "#") || len(lit) < 2 {
+		return 10, lit
+	}
+	switch lit[1] {
+	case 'x', 'X':
+		return 16, lit[2:]
+	case 'o', 'O':
+		return 8, lit[2:]
+	case 'b', 'B':
+		return 2, lit[2:]
+	}
+	pe := strings.IndexAny(lit, "rR")
+	if pe < 0 {
+		return 0, ""
+	}
+	radix, err := strconv.Atoi(lit[1:pe])
+	if err != nil {
+		return 0, ""
 	}
+	return radix, lit[pe+1:]
+}
+
+// parse integer literal ("1." is also an integer in Emacs)
+// The result is *big.Int when the value does not fit in int.
+func parseIntLiteral(lit string) interface{} {
+	radix, digits := radixOf(strings.TrimSuffix(lit, "."))
+	i, err := strconv.ParseInt(digits, radix, 0)
+	if err == nil {
+		return int(i)
+	}
+	b, ok := new(big.Int).SetString(digits, radix)
+	if !ok {
+		return 0
+	}
+	return b
+}
+
+// convert bool-vector literal
+// ex: BoolVectorLiteral([]bool{true, false, true}) -> #&3"\005"
+func BoolVectorLiteral(bits []bool) string {
+	bs := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			bs[i/8] |= 1 << uint(i%8)
+		}
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString("#&" + strconv.Itoa(len(bits)) + `"`)
+	for _, b := range bs {
+		if 0x20 <= b && b < 0x7f && b != '\\' && b != '"' {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteString(fmt.Sprintf("\\%03o", b))
+		}
+	}
+	buf.WriteByte('"')
 	return buf.String()
 }
+
+// parse bool-vector literal, such as #&3"\005"
+func parseBoolVector(lit string) ([]bool, error) {
+	pe := strings.IndexByte(lit, '"')
+	n, err := strconv.Atoi(lit[2:pe])
+	if err != nil {
+		return nil, fmt.Errorf("invalid length: %s", lit)
+	}
+	bs, err := UnescapeString(lit[pe+1 : len(lit)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(bs) != (n+7)/8 {
+		return nil, fmt.Errorf("length %d does not match the data: %s", n, lit)
+	}
+	bits := make([]bool, n)
+	for i := range bits {
+		bits[i] = bs[i/8]&(1<<uint(i%8)) != 0
+	}
+	return bits, nil
+}
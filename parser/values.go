@@ -0,0 +1,55 @@
+package parser
+
+// Go objects transformed from AST, which can not be expressed by the
+// built-in types.
+
+// Symbol is an Emacs symbol, such as major-mode.
+type Symbol string
+
+// Keyword is an Emacs keyword symbol without the leading colon,
+// such as success for :success.
+type Keyword string
+
+// Cons is an Emacs cons cell, such as (a . b) for Cons{"a", "b"}.
+// An improper list (a b . c) is nested as Cons{"a", Cons{"b", "c"}}.
+type Cons struct {
+	Car interface{}
+	Cdr interface{}
+}
+
+// HashTable is an Emacs hash table, printed as
+// #s(hash-table test equal data (k1 v1 k2 v2)). The empty Test means the
+// default test, eql. When a key can not be a Go map key, such as a list,
+// the table is decoded into Alist instead of Data, which keeps all the
+// entries in the order.
+type HashTable struct {
+	Test  string
+	Data  map[interface{}]interface{}
+	Alist []Cons // the entries, used instead of Data when it is not nil
+}
+
+// Record is an Emacs record, such as a cl-defstruct object, printed as
+// #s(type slot1 slot2).
+type Record struct {
+	Type  string
+	Slots []interface{}
+}
+
+// BoolVector is an Emacs bool-vector, printed as #&N"...".
+type BoolVector []bool
+
+// PropertizedString is an Emacs string with text properties, printed as
+// #("text" 0 4 (face bold)).
+type PropertizedString struct {
+	Text      string
+	Intervals []TextInterval
+}
+
+// TextInterval is a range of characters with the text properties. Start
+// and End are character positions like Emacs, not byte offsets of Text.
+// The property names are the symbol names, such as face.
+type TextInterval struct {
+	Start int
+	End   int
+	Props map[string]interface{}
+}
@@ -0,0 +1,192 @@
+package parser
+
+// Accessors of the AST nodes. The returned slices are shared with the
+// nodes and should not be modified.
+
+// Name returns the name of the symbol.
+func (s *SExpSymbol) Name() string { return s.literal }
+
+// Car returns the car of the pair.
+func (s *SExpCons) Car() SExp { return s.car }
+
+// Cdr returns the cdr of the pair.
+func (s *SExpCons) Cdr() SExp { return s.cdr }
+
+// Elements returns the elements of the list.
+func (s *SExpList) Elements() []SExp { return s.elements }
+
+// Elements returns the elements of the list before the dot.
+func (s *SExpListDot) Elements() []SExp { return s.elements }
+
+// Last returns the element after the dot.
+func (s *SExpListDot) Last() SExp { return s.last }
+
+// Elements returns the elements of the vector.
+func (s *SExpVector) Elements() []SExp { return s.elements }
+
+// Elements returns the type and the slots of the record.
+func (s *SExpRecord) Elements() []SExp { return s.elements }
+
+// Type returns the type of the record.
+func (s *SExpRecord) Type() SExp { return s.elements[0] }
+
+// Slots returns the slots of the record.
+func (s *SExpRecord) Slots() []SExp { return s.elements[1:] }
+
+// Props returns the properties of the hash table except data, such as
+// test and size, as a property list.
+func (s *SExpHashTable) Props() []SExp { return s.props }
+
+// Data returns the keys and the values of the hash table alternately.
+func (s *SExpHashTable) Data() []SExp { return s.data }
+
+// Bits returns the bits of the bool-vector.
+func (s *SExpBoolVector) Bits() []bool { return s.bits }
+
+// Text returns the text of the propertized string.
+func (s *SExpPropertizedString) Text() string { return s.text }
+
+// Props returns the text properties as the triples of the start, the end
+// and the property list.
+func (s *SExpPropertizedString) Props() []SExp { return s.props }
+
+// Inner returns the quoted expression.
+func (s *SExpQuoted) Inner() SExp { return s.sexp }
+
+// IsFunction reports whether it is the function quote, #'.
+func (s *SExpQuoted) IsFunction() bool { return s.function }
+
+// Inner returns the backquoted expression.
+func (s *SExpQuasiQuoted) Inner() SExp { return s.sexp }
+
+// Inner returns the unquoted expression.
+func (s *SExpUnquote) Inner() SExp { return s.sexp }
+
+// IsSplice reports whether it is the splicing unquote, ,@.
+func (s *SExpUnquote) IsSplice() bool { return s.splice }
+
+// Label returns the label number.
+func (s *SExpLabel) Label() int { return s.label }
+
+// Inner returns the labelled expression.
+func (s *SExpLabel) Inner() SExp { return s.sexp }
+
+// Label returns the label number.
+func (s *SExpLabelRef) Label() int { return s.label }
+
+// Target returns the labelled expression referred, or nil if undefined.
+func (s *SExpLabelRef) Target() SExp {
+	if s.target == nil {
+		return nil
+	}
+	return s.target
+}
+
+// Children returns the child nodes in the source order. The targets of
+// the label references are not included.
+func Children(s SExp) []SExp {
+	return children(s)
+}
+
+// Walk traverses the tree in depth-first order. pre is called before
+// the children of a node, and they are skipped when it returns false.
+// post is called after them. Either of the functions may be nil.
+func Walk(s SExp, pre func(SExp) bool, post func(SExp)) {
+	if pre != nil && !pre(s) {
+		return
+	}
+	for _, c := range children(s) {
+		Walk(c, pre, post)
+	}
+	if post != nil {
+		post(s)
+	}
+}
+
+// Inspect calls f for the nodes of the tree in pre-order, like
+// ast.Inspect of the go/ast package.
+func Inspect(s SExp, f func(SExp) bool) {
+	Walk(s, f, nil)
+}
+
+// Query returns the nodes of the trees matching the pattern in pre-order.
+// The pattern is an S-expression in which the symbol _ matches any node
+// and _* matches the rest elements of any length:
+//
+//	Query(sexps, "(defun _ _*)")          ; all defun forms
+//	Query(sexps, "(require '_)")          ; all required features
+//	Query(sexps, "(setq _* load-path _*)")
+func Query(sexps []SExp, pattern string) ([]SExp, error) {
+	pats, err := Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(pats) != 1 {
+		return nil, &Error{Msg: "the pattern should be one S-expression"}
+	}
+	var res []SExp
+	for _, s := range sexps {
+		Inspect(s, func(n SExp) bool {
+			if Match(pats[0], n) {
+				res = append(res, n)
+			}
+			return true
+		})
+	}
+	return res, nil
+}
+
+// Match reports whether the node matches the pattern. See Query for the
+// wildcards.
+func Match(pattern, s SExp) bool {
+	if isWildcard(pattern, "_") {
+		return true
+	}
+	switch p := pattern.(type) {
+	case *SExpList:
+		t, ok := s.(*SExpList)
+		return ok && matchSeq(p.elements, t.elements)
+	case *SExpListDot:
+		t, ok := s.(*SExpListDot)
+		return ok && matchSeq(p.elements, t.elements) && Match(p.last, t.last)
+	case *SExpCons:
+		t, ok := s.(*SExpCons)
+		return ok && Match(p.car, t.car) && Match(p.cdr, t.cdr)
+	case *SExpVector:
+		t, ok := s.(*SExpVector)
+		return ok && matchSeq(p.elements, t.elements)
+	case *SExpRecord:
+		t, ok := s.(*SExpRecord)
+		return ok && matchSeq(p.elements, t.elements)
+	case *SExpQuoted:
+		t, ok := s.(*SExpQuoted)
+		return ok && p.function == t.function && Match(p.sexp, t.sexp)
+	case *SExpQuasiQuoted:
+		t, ok := s.(*SExpQuasiQuoted)
+		return ok && Match(p.sexp, t.sexp)
+	case *SExpUnquote:
+		t, ok := s.(*SExpUnquote)
+		return ok && p.splice == t.splice && Match(p.sexp, t.sexp)
+	}
+	return len(children(s)) == 0 && pattern.ToSExpString() == s.ToSExpString()
+}
+
+func matchSeq(pats, elements []SExp) bool {
+	if len(pats) == 0 {
+		return len(elements) == 0
+	}
+	if isWildcard(pats[0], "_*") {
+		for i := 0; i <= len(elements); i++ {
+			if matchSeq(pats[1:], elements[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(elements) > 0 && Match(pats[0], elements[0]) && matchSeq(pats[1:], elements[1:])
+}
+
+func isWildcard(s SExp, name string) bool {
+	sym, ok := s.(*SExpSymbol)
+	return ok && sym.literal == name
+}
@@ -6,26 +6,57 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kiwanami/go-elrpc/parser"
 )
 
-var uidCounter struct {
-	mu    sync.Mutex
-	count int
+// maxUID is the largest uid, most-positive-fixnum of the 32-bit Emacs.
+// The uid sequence wraps around to 1 after that.
+const maxUID = 1<<29 - 1
+
+// UIDEventKind is the kind of the diagnostic events about the uids.
+type UIDEventKind int
+
+const (
+	// UIDUnknown is a reply with a uid which this connection never sent.
+	UIDUnknown UIDEventKind = iota
+	// UIDLate is a reply for a finished session, such as a duplicate
+	// reply or a reply after the cancel.
+	UIDLate
+	// UIDDuplicate is a call with the uid of a call still running.
+	UIDDuplicate
+	// UIDWraparound is the uid sequence wrapping around to 1.
+	UIDWraparound
+)
+
+func (k UIDEventKind) String() string {
+	switch k {
+	case UIDUnknown:
+		return "unknown uid"
+	case UIDLate:
+		return "late or duplicate reply"
+	case UIDDuplicate:
+		return "duplicate call uid"
+	case UIDWraparound:
+		return "uid wraparound"
+	}
+	return fmt.Sprintf("UIDEventKind(%d)", int(k))
 }
 
-func genuid() int {
-	uidCounter.mu.Lock()
-	defer uidCounter.mu.Unlock()
-	uidCounter.count++
-	return uidCounter.count
+// UIDEvent is a diagnostic event about the uids. The rejected messages
+// are dropped after the event.
+type UIDEvent struct {
+	Kind    UIDEventKind
+	UID     int
+	Message string // message type, such as "return"
 }
 
 /// RPCServer
@@ -46,29 +77,202 @@ type Method struct {
 	mtype     reflect.Type
 	mfunc     reflect.Value
 	argTypes  []reflect.Type
+	withCtx   bool // the first argument is context.Context
 	argdoc    string
 	docstring string
+	limiter   *limiter
+	timeout   time.Duration
 }
 
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// MakeMethod makes a method from the function. If the first argument of
+// the function is context.Context, it receives the context of the call,
+// which is cancelled at the deadline of the method.
 func MakeMethod(name string, proc interface{}, argdoc string, docstring string) *Method {
 	mtype := reflect.TypeOf(proc)
 	if mtype.Kind() != reflect.Func {
 		panic(fmt.Sprintf("not a function : %v (name=%s)", proc, name))
 	}
-	ats := make([]reflect.Type, mtype.NumIn())
-	for i := 0; i < len(ats); i++ {
-		ats[i] = mtype.In(i)
+	withCtx := mtype.NumIn() > 0 && mtype.In(0) == contextType
+	var ats []reflect.Type
+	for i := 0; i < mtype.NumIn(); i++ {
+		if i == 0 && withCtx {
+			continue
+		}
+		ats = append(ats, mtype.In(i))
 	}
 	return &Method{
 		name:      name,
 		mtype:     mtype,
 		argTypes:  ats,
+		withCtx:   withCtx,
 		mfunc:     reflect.ValueOf(proc),
 		argdoc:    argdoc,
 		docstring: docstring,
 	}
 }
 
+// SetConcurrencyLimit limits the calls of the method running at once.
+// The limit is shared by all connections the method is registered to.
+// It returns the method itself.
+func (m *Method) SetConcurrencyLimit(l ConcurrencyLimit) *Method {
+	m.limiter = newLimiter(l)
+	return m
+}
+
+// SetTimeout sets the deadline of the calls of the method. At the
+// deadline, the context of the call is cancelled and the caller gets a
+// timeout error, and the result after that is discarded. Zero means no
+// deadline. It returns the method itself.
+func (m *Method) SetTimeout(d time.Duration) *Method {
+	m.timeout = d
+	return m
+}
+
+// Serialize makes the calls of the method run one by one, for the
+// handlers which must not overlap. It returns the method itself.
+func (m *Method) Serialize() *Method {
+	return m.SetConcurrencyLimit(ConcurrencyLimit{Max: 1})
+}
+
+// ConcurrencyLimit is the limit of the calls from the peer running at
+// once. The calls over the limit wait for the running calls, or are
+// rejected with epc-error if Reject is true. The calls over MaxPending
+// waiting ones are rejected too.
+type ConcurrencyLimit struct {
+	Max        int // zero means no limit
+	Reject     bool
+	MaxPending int // zero means DefaultMaxPending
+}
+
+// DefaultMaxPending is the default number of the calls waiting for the
+// limit.
+const DefaultMaxPending = 256
+
+// CallInfo describes a call passing the interceptors. The interceptors
+// may change the arguments. UID is zero for the calls to the peer, whose
+// uid is not generated yet.
+type CallInfo struct {
+	Method string
+	UID    int
+	Args   []interface{}
+}
+
+// Handler executes the call, or the rest of the interceptor chain.
+type Handler func(ctx context.Context, info *CallInfo) (interface{}, error)
+
+// Interceptor is a middleware of the calls, such as logging, the auth
+// checks and the metrics. It continues the call with next, or returns
+// without calling it.
+type Interceptor func(ctx context.Context, info *CallInfo, next Handler) (interface{}, error)
+
+// chain makes the handler calling the interceptors in order.
+func chain(is []Interceptor, h Handler) Handler {
+	for i := len(is) - 1; i >= 0; i-- {
+		ic, next := is[i], h
+		h = func(ctx context.Context, info *CallInfo) (interface{}, error) {
+			return ic(ctx, info, next)
+		}
+	}
+	return h
+}
+
+// invoke calls the function with the arguments, and returns the first
+// result.
+func (m *Method) invoke(ctx context.Context, args []interface{}) interface{} {
+	argv := make([]reflect.Value, 0, len(args)+1)
+	if m.withCtx {
+		argv = append(argv, reflect.ValueOf(ctx))
+	}
+	for i, a := range args {
+		av := reflect.ValueOf(a)
+		if !av.IsValid() && i < len(m.argTypes) {
+			av = reflect.Zero(m.argTypes[i])
+		}
+		argv = append(argv, av)
+	}
+	retv := m.mfunc.Call(argv)
+	if len(retv) == 0 {
+		return nil
+	}
+	return retv[0].Interface()
+}
+
+// ErrCallTimeout is the reason of the calls which exceed the call timeout
+// or the deadline of the method on the peer.
+var ErrCallTimeout = errors.New("call timeout")
+
+func timeoutError(name string) error {
+	return fmt.Errorf("%w: name=%s", ErrCallTimeout, name)
+}
+
+// ErrTooManyCalls is the reason of the calls rejected by the limits.
+var ErrTooManyCalls = errors.New("too many calls")
+
+// epcErrorCodes are the codes in the epc-errors for the reasons, for the
+// peers to tell them without parsing the messages.
+var epcErrorCodes = map[string]error{
+	"call-timeout":   ErrCallTimeout,
+	"too-many-calls": ErrTooManyCalls,
+}
+
+// limiter is a semaphore for the calls. The nil limiter has no limit.
+type limiter struct {
+	sem     chan struct{}
+	pending chan struct{} // the calls waiting for sem
+	reject  bool
+}
+
+func newLimiter(l ConcurrencyLimit) *limiter {
+	if l.Max <= 0 {
+		return nil
+	}
+	lm := &limiter{sem: make(chan struct{}, l.Max), reject: l.Reject}
+	if !l.Reject {
+		n := l.MaxPending
+		if n <= 0 {
+			n = DefaultMaxPending
+		}
+		lm.pending = make(chan struct{}, n)
+	}
+	return lm
+}
+
+// acquire takes a slot, waiting for it while the pending queue has room
+// unless the limiter rejects. It gives up the wait at the cancel.
+func (l *limiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+	}
+	if l.reject {
+		return false
+	}
+	select {
+	case l.pending <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-l.pending }()
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *limiter) release() {
+	if l != nil {
+		<-l.sem
+	}
+}
+
 type MethodDesc struct {
 	Name      string
 	Argdoc    string
@@ -81,9 +285,28 @@ type methodResult struct {
 	err     error
 }
 
+// message is written into the encoder directly, without building the AST.
 type message interface {
 	msgID() int
-	ToAst() (parser.SExp, error)
+	encodeTo(e *encodeState) error
+}
+
+// head writes the message type and the uid, such as "(return 1".
+func (e *encodeState) head(mtype string, uid int) {
+	e.WriteByte('(')
+	e.WriteString(mtype)
+	e.WriteByte(' ')
+	e.Write(strconv.AppendInt(e.scratch[:0], int64(uid), 10))
+}
+
+// tail writes the value and closes the message.
+func (e *encodeState) tail(v interface{}) error {
+	e.WriteByte(' ')
+	if err := e.encode(v); err != nil {
+		return err
+	}
+	e.WriteByte(')')
+	return nil
 }
 
 type messageCall struct {
@@ -96,16 +319,11 @@ func (m *messageCall) msgID() int {
 	return m.uid
 }
 
-func (m *messageCall) ToAst() (parser.SExp, error) {
-	val, err := Encode(m.args)
-	if err != nil {
-		return nil, err
-	}
-	return parser.AstListv(
-		parser.AstSymbol("call"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-		parser.AstString(m.method), parser.AstWrapper(val),
-	), nil
+func (m *messageCall) encodeTo(e *encodeState) error {
+	e.head("call", m.uid)
+	e.WriteByte(' ')
+	_, _ = e.string(m.method)
+	return e.tail(m.args)
 }
 
 type messageMethod struct {
@@ -116,11 +334,10 @@ func (m *messageMethod) msgID() int {
 	return m.uid
 }
 
-func (m *messageMethod) ToAst() (parser.SExp, error) {
-	return parser.AstListv(
-		parser.AstSymbol("methods"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-	), nil
+func (m *messageMethod) encodeTo(e *encodeState) error {
+	e.head("methods", m.uid)
+	e.WriteByte(')')
+	return nil
 }
 
 type messageReturn struct {
@@ -132,16 +349,9 @@ func (m *messageReturn) msgID() int {
 	return m.uid
 }
 
-func (m *messageReturn) ToAst() (parser.SExp, error) {
-	val, err := Encode(m.value)
-	if err != nil {
-		return nil, err
-	}
-	return parser.AstListv(
-		parser.AstSymbol("return"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-		parser.AstWrapper(val),
-	), nil
+func (m *messageReturn) encodeTo(e *encodeState) error {
+	e.head("return", m.uid)
+	return e.tail(m.value)
 }
 
 type messageError struct {
@@ -153,37 +363,29 @@ func (m *messageError) msgID() int {
 	return m.uid
 }
 
-func (m *messageError) ToAst() (parser.SExp, error) {
-	val, err := Encode(m.msg)
-	if err != nil {
-		return nil, err
-	}
-	return parser.AstListv(
-		parser.AstSymbol("return-error"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-		parser.AstWrapper(val),
-	), nil
+func (m *messageError) encodeTo(e *encodeState) error {
+	e.head("return-error", m.uid)
+	return e.tail(m.msg)
 }
 
 type messageEpcError struct {
-	uid int
-	msg string
+	uid  int
+	msg  string
+	code string // the symbol of epcErrorCodes, or empty
 }
 
 func (m *messageEpcError) msgID() int {
 	return m.uid
 }
 
-func (m *messageEpcError) ToAst() (parser.SExp, error) {
-	val, err := Encode(m.msg)
-	if err != nil {
-		return nil, err
+// encodeTo writes the message, tagged as "[code] message" with the code.
+// The payload stays a string for the peers which don't know the codes.
+func (m *messageEpcError) encodeTo(e *encodeState) error {
+	e.head("epc-error", m.uid)
+	if m.code != "" {
+		return e.tail("[" + m.code + "] " + m.msg)
 	}
-	return parser.AstListv(
-		parser.AstSymbol("epc-error"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-		parser.AstWrapper(val),
-	), nil
+	return e.tail(m.msg)
 }
 
 type messageCancel struct {
@@ -194,11 +396,10 @@ func (m *messageCancel) msgID() int {
 	return m.uid
 }
 
-func (m *messageCancel) ToAst() (parser.SExp, error) {
-	return parser.AstListv(
-		parser.AstSymbol("cancel"),
-		parser.AstInt(strconv.Itoa(m.uid)),
-	), nil
+func (m *messageCancel) encodeTo(e *encodeState) error {
+	e.head("cancel", m.uid)
+	e.WriteByte(')')
+	return nil
 }
 
 /// error
@@ -227,7 +428,6 @@ type workerMsg int
 
 const (
 	_ workerMsg = iota
-	workerClose
 	workerClosed
 )
 
@@ -245,80 +445,219 @@ type serverMsg struct {
 }
 
 type RPCServer struct {
-	logger       *log.Logger
-	debugMode    bool
-	methods      map[string]*Method
+	name         string
+	logLevel     *slog.LevelVar
+	config       atomic.Pointer[config]
+	configMutex  sync.Mutex // serializes the updates of config
 	session      map[int]chan *methodResult
-	sessionMutex sync.RWMutex
+	calls        map[int]context.CancelFunc // the running calls from the peer
+	lastUID      int
+	wrapped      bool
+	sessionMutex sync.RWMutex // guards session, calls and the uids
 	socket       net.Conn
 	socketOut    *bufio.Writer
+	sendBuf      encodeState // used by the sender only
 
-	sendingQueue chan message
+	sendingQueue *sendQueue
 
-	socketState socketState
+	socketState atomic.Int32    // socketState, written by the server worker only
 	user2svChan chan *serverMsg // channel from user to server
 	rcv2svChan  chan workerMsg  // channel from receiver to server
 	snd2svChan  chan workerMsg  // channel from sender to server
-	sv2sndChan  chan workerMsg  // channel from server to sender
+	stopSender  chan struct{}   // closed to stop the sender
+	stopOnce    sync.Once       // for stopSender
+	done        chan struct{}   // closed when the server worker exits
 	exitHook    []func()        // server exit hook function
 }
 
+// config is the settings of a connection. The setters replace the whole
+// config, so that the workers read it without locks.
+type config struct {
+	logger       *slog.Logger // with the conn attribute, filtered by logLevel
+	decodeOpts   *parser.ValueOptions
+	methods      map[string]*Method
+	writeTimeout time.Duration
+	callTimeout  time.Duration
+	uidHandler   func(UIDEvent)
+	limiter      *limiter      // for all calls from the peer
+	interceptors []Interceptor // for the calls from the peer
+	clientIcs    []Interceptor // for the calls to the peer
+}
+
+func (s *RPCServer) cfg() *config {
+	return s.config.Load()
+}
+
+// update changes a copy of the config, and replaces the config with it.
+func (s *RPCServer) update(f func(c *config)) {
+	s.configMutex.Lock()
+	defer s.configMutex.Unlock()
+	c := *s.config.Load()
+	f(&c)
+	s.config.Store(&c)
+}
+
+func (s *RPCServer) logger() *slog.Logger {
+	return s.cfg().logger
+}
+
+// debugging reports whether the debug logs are written, not to format
+// the costly attributes, such as the message bodies, for nothing.
+func (s *RPCServer) debugging() bool {
+	return s.logger().Enabled(context.Background(), slog.LevelDebug)
+}
+
+// SetDebug switches the log level between debug and info.
 func (s *RPCServer) SetDebug(d bool) {
-	s.debugMode = d
+	s.SetLogLevel(debugLevel(d))
+}
+
+// SetLogger sets the logger. The records are written with the conn
+// attribute, the name of the connection. Nil means the default logger.
+func (s *RPCServer) SetLogger(l *slog.Logger) {
+	l = newLogger(l, s.logLevel).With("conn", s.name)
+	s.update(func(c *config) { c.logger = l })
+}
+
+// SetLogLevel sets the minimum level of the logs.
+func (s *RPCServer) SetLogLevel(l slog.Level) {
+	s.logLevel.Set(l)
+}
+
+// SetDecodeOptions sets the options to decode incoming messages into Go objects.
+func (s *RPCServer) SetDecodeOptions(o *parser.ValueOptions) {
+	s.update(func(c *config) { c.decodeOpts = o })
+}
+
+// SetSendQueueSize sets the number of the messages waiting for the
+// sender. When the queue is full, the callers wait for room up to the
+// write timeout or the context, and fail with ErrSendQueueFull.
+func (s *RPCServer) SetSendQueueSize(n int) {
+	s.sendingQueue.setLimit(n)
+}
+
+// SetWriteTimeout sets the deadline to write a message to the socket.
+// A stalled peer makes the writes fail after that, and the connection is
+// closed. It also bounds the wait for room in the full sending queue.
+// Zero means no timeout, and the full queue fails at once.
+func (s *RPCServer) SetWriteTimeout(d time.Duration) {
+	s.update(func(c *config) { c.writeTimeout = d })
+}
+
+// SetCallTimeout sets the default timeout of the calls to the peer. The
+// calls fail with ErrCallTimeout after that. CallContext uses it only for
+// the contexts without deadline. Zero means no timeout.
+func (s *RPCServer) SetCallTimeout(d time.Duration) {
+	s.update(func(c *config) { c.callTimeout = d })
+}
+
+// SetInterceptors sets the interceptors of the calls from the peer. They
+// run in order before the method, within the limits and the deadline of
+// the method. An error of them is returned to the peer as return-error.
+func (s *RPCServer) SetInterceptors(is ...Interceptor) {
+	s.update(func(c *config) { c.interceptors = is })
+}
+
+// SetClientInterceptors sets the interceptors of Call and CallContext.
+func (s *RPCServer) SetClientInterceptors(is ...Interceptor) {
+	s.update(func(c *config) { c.clientIcs = is })
+}
+
+// SetConcurrencyLimit limits the calls from the peer running at once on
+// this connection. The limits of the methods are applied too.
+func (s *RPCServer) SetConcurrencyLimit(l ConcurrencyLimit) {
+	s.setLimiter(newLimiter(l))
+}
+
+// setLimiter sets the limiter, which may be shared with the other
+// connections.
+func (s *RPCServer) setLimiter(lm *limiter) {
+	s.update(func(c *config) { c.limiter = lm })
 }
 
-func (s *RPCServer) debugf(format string, args ...interface{}) {
-	if s.debugMode {
-		s.logger.Printf(format, args...)
+// SetUIDEventHandler sets the function called with the diagnostic
+// events about the uids, such as a reply for an unknown session.
+func (s *RPCServer) SetUIDEventHandler(f func(UIDEvent)) {
+	s.update(func(c *config) { c.uidHandler = f })
+}
+
+func (s *RPCServer) uidEvent(kind UIDEventKind, uid int, mtype string) {
+	s.logger().Warn("uid rejected", "event", kind.String(), "uid", uid, "mtype", mtype)
+	if f := s.cfg().uidHandler; f != nil {
+		f(UIDEvent{Kind: kind, UID: uid, Message: mtype})
 	}
 }
 
 func makeRPCServer(name string, socket net.Conn, methods []*Method) *RPCServer {
-	logger := log.New(os.Stderr, fmt.Sprintf("%s ", name), log.Ldate|log.Ltime)
+	server := newRPCServer(name, socket, methods)
+	server.start()
+	return server
+}
+
+// newRPCServer makes the server without starting the workers, so that
+// the settings are applied before the first message.
+func newRPCServer(name string, socket net.Conn, methods []*Method) *RPCServer {
 	server := &RPCServer{
-		logger:       logger,
-		socketState:  socketStateOpened,
+		name:         name,
+		logLevel:     new(slog.LevelVar),
 		socket:       socket,
 		socketOut:    bufio.NewWriter(socket),
-		methods:      make(map[string]*Method),
 		session:      make(map[int]chan *methodResult),
-		sendingQueue: make(chan message, 20),
+		calls:        make(map[int]context.CancelFunc),
+		sendingQueue: newSendQueue(defaultSendQueueSize),
 
 		user2svChan: make(chan *serverMsg, 1),
 		rcv2svChan:  make(chan workerMsg, 1),
 		snd2svChan:  make(chan workerMsg, 1),
-		sv2sndChan:  make(chan workerMsg, 1),
+		stopSender:  make(chan struct{}),
+		done:        make(chan struct{}),
 		exitHook:    []func(){},
 	}
+	server.socketState.Store(int32(socketStateOpened))
+	server.config.Store(&config{
+		methods:      make(map[string]*Method),
+		writeTimeout: DefaultWriteTimeout,
+	})
+	server.SetLogLevel(defaultLogLevel.slogLevel())
+	server.SetLogger(defaultLogger)
 
-	if methods != nil {
-		for _, m := range methods {
-			server.RegisterMethod(m)
-		}
+	for _, m := range methods {
+		server.RegisterMethod(m)
 	}
+	return server
+}
+
+func (s *RPCServer) start() {
+	go s.serverWorker()
+	go s.senderWorker()
+	go s.receiverWorker()
+}
 
-	go server.serverWorker()
-	go server.senderWorker()
-	go server.receiverWorker()
+func (s *RPCServer) state() socketState {
+	return socketState(s.socketState.Load())
+}
 
-	return server
+// closeSender tells the sender to exit, only once.
+func (s *RPCServer) closeSender() {
+	s.stopOnce.Do(func() { close(s.stopSender) })
 }
 
 func (s *RPCServer) serverWorker() {
+	defer close(s.done) // after the responses in the deferred functions
 	var socketErr error
 	receiverState := true
 	senderState := true
 	for {
 		select {
 		case ev := <-s.user2svChan:
-			s.debugf("ServerWorker: receive comm signal [%v]", ev)
+			s.logger().Debug("ServerWorker: receive comm signal", "signal", ev)
 			switch ev.msg {
 			case serverStop:
-				if s.socketState == socketStateOpened {
-					s.debugf("ServerWorker: sending stop signal")
-					s.socketState = socketStateClosing
+				if s.state() == socketStateOpened {
+					s.logger().Debug("ServerWorker: sending stop signal")
+					s.socketState.Store(int32(socketStateClosing))
 					socketErr = s.socket.Close()
-					go func() { s.sv2sndChan <- workerClose }()
+					s.closeSender()
 				}
 				defer func() {
 					ev.response <- socketErr
@@ -329,38 +668,32 @@ func (s *RPCServer) serverWorker() {
 				})
 			}
 		case rev := <-s.rcv2svChan:
-			s.debugf("ServerWorker: receive receiver signal [%v]", rev)
+			s.logger().Debug("ServerWorker: receive receiver signal", "signal", rev)
 			if rev == workerClosed {
 				receiverState = false
-				if s.socketState == socketStateOpened {
-					s.debugf("ServerWorker: stop signal from receiver")
-					if senderState {
-						go func() { s.sv2sndChan <- workerClose }()
-					}
+				if s.state() == socketStateOpened {
+					s.logger().Debug("ServerWorker: stop signal from receiver")
+					s.closeSender()
 				}
 			} else {
-				s.debugf("ServerWorker: invalid receiver msg: %v", rev)
+				s.logger().Debug("ServerWorker: invalid receiver msg", "signal", rev)
 			}
 		case sev := <-s.snd2svChan:
-			s.debugf("ServerWorker: receive sender signal [%v]", sev)
+			s.logger().Debug("ServerWorker: receive sender signal", "signal", sev)
 			if sev == workerClosed {
 				senderState = false
 			}
 		}
-		s.debugf("ServerWorker state: send:%v,  recv:%v", senderState, receiverState)
+		s.logger().Debug("ServerWorker state", "send", senderState, "recv", receiverState)
 		if !receiverState && !senderState {
-			s.socketState = socketStateNotConnected
+			s.socketState.Store(int32(socketStateNotConnected))
 			break
 		}
 	}
-	close(s.snd2svChan)
-	close(s.sv2sndChan)
-	close(s.rcv2svChan)
 	s.cleanupSessions()
 	s.execExitHook()
-	s.debugf("ServerWorker exited: sockerr: %v, send:%v,  recv:%v",
-		socketErr, senderState, receiverState)
-	close(s.user2svChan)
+	s.logger().Debug("ServerWorker exited",
+		"sockerr", socketErr, "send", senderState, "recv", receiverState)
 }
 
 func (s *RPCServer) addExitHook(f func()) {
@@ -369,8 +702,15 @@ func (s *RPCServer) addExitHook(f func()) {
 		response: make(chan interface{}, 1),
 	}
 	go func() {
-		s.user2svChan <- msg
-		<-msg.response // wait for exit event
+		select {
+		case s.user2svChan <- msg:
+			// wait for exit event, or the exit before receiving msg
+			select {
+			case <-msg.response:
+			case <-s.done:
+			}
+		case <-s.done: // already exited
+		}
 		f()
 	}()
 }
@@ -378,7 +718,7 @@ func (s *RPCServer) addExitHook(f func()) {
 func (s *RPCServer) execExitHook() {
 	defer func() {
 		if r := recover(); r != nil {
-			s.logger.Printf("ExitHook: panic error : %+v\n", r)
+			s.logger().Error("ExitHook: panic error", "panic", r)
 		}
 	}()
 	if len(s.exitHook) > 0 {
@@ -404,48 +744,262 @@ func (s *RPCServer) cleanupSessions() {
 	}
 }
 
+const defaultSendQueueSize = 20
+
+// ErrSendQueueFull is returned when a message can not be queued in time,
+// because the peer does not read the messages.
+var ErrSendQueueFull = errors.New("epc sending queue is full")
+
+var errQueueClosed = errors.New("epc not connected")
+
+// sendQueue is the bounded queue of the messages for the sender.
+type sendQueue struct {
+	mu     sync.Mutex
+	msgs   []message
+	limit  int
+	ready  chan struct{} // notifies the sender of new messages
+	space  chan struct{} // closed when the full queue gets room
+	closed chan struct{}
+}
+
+func newSendQueue(limit int) *sendQueue {
+	return &sendQueue{
+		limit:  limit,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}),
+		closed: make(chan struct{}),
+	}
+}
+
+// add queues the message if the queue has room. Otherwise it returns
+// the channel closed when the queue gets room.
+func (q *sendQueue) add(m message) (chan struct{}, error) {
+	q.mu.Lock()
+	select {
+	case <-q.closed:
+		q.mu.Unlock()
+		return nil, errQueueClosed
+	default:
+	}
+	if len(q.msgs) >= q.limit {
+		space := q.space
+		q.mu.Unlock()
+		return space, nil
+	}
+	q.msgs = append(q.msgs, m)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil, nil
+}
+
+// push queues the message, waiting for room until ctx is done.
+func (q *sendQueue) push(ctx context.Context, m message) error {
+	for {
+		space, err := q.add(m)
+		if space == nil {
+			return err
+		}
+		select {
+		case <-space:
+		case <-q.closed:
+			return errQueueClosed
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ErrSendQueueFull, ctx.Err())
+		}
+	}
+}
+
+// tryPush queues the message without waiting for room.
+func (q *sendQueue) tryPush(m message) error {
+	space, err := q.add(m)
+	if space != nil {
+		return ErrSendQueueFull
+	}
+	return err
+}
+
+func (q *sendQueue) pop() (message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+	m := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	if len(q.msgs) == q.limit-1 {
+		q.notifySpace()
+	}
+	return m, true
+}
+
+func (q *sendQueue) setLimit(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if n < 1 {
+		n = 1
+	}
+	if n > q.limit {
+		q.notifySpace()
+	}
+	q.limit = n
+}
+
+func (q *sendQueue) notifySpace() {
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case <-q.closed:
+	default:
+		close(q.closed)
+	}
+}
+
+// DefaultWriteTimeout is the write timeout of the connections, unless
+// SetWriteTimeout changes it.
+const DefaultWriteTimeout = 30 * time.Second
+
+// queue puts the message into the sending queue. When the queue is full,
+// it waits for room until ctx is done, up to the write timeout. Without
+// the write timeout, it fails with ErrSendQueueFull at once.
+func (s *RPCServer) queue(ctx context.Context, m message) error {
+	d := s.cfg().writeTimeout
+	if d <= 0 {
+		return s.sendingQueue.tryPush(m)
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	return s.sendingQueue.push(ctx, m)
+}
+
+// queueReply queues the reply, such as the one from the receiver, and
+// logs the failure.
+func (s *RPCServer) queueReply(m message) {
+	if err := s.queue(context.Background(), m); err != nil {
+		s.logger().Error("could not queue a message", "uid", m.msgID(), "err", err)
+	}
+}
+
 func (s *RPCServer) senderWorker() {
 	defer func() {
 		if r := recover(); r != nil {
-			s.logger.Printf("SenderWoker: panic error : %+v\n", r)
+			s.logger().Error("SenderWoker: panic error", "panic", r)
 		}
 	}()
+	defer s.sendingQueue.close()
 Loop:
 	for {
 		select {
-		case <-s.sv2sndChan:
-			s.debugf("SenderWoker: received stop message.")
+		case <-s.stopSender:
+			s.logger().Debug("SenderWoker: received stop message")
 			break Loop
-		case sndmsg := <-s.sendingQueue:
-			s.debugf("SenderWoker: pop a message : %v\n", sndmsg)
-			err := s.sendMessage(sndmsg)
-			if err != nil {
-				s.logger.Println("SenderWoker: error : " + err.Error())
-				if _, ok := sndmsg.(*messageReturn); ok {
-					// notify remote receiver
-					errmsg := &messageEpcError{
-						uid: sndmsg.msgID(),
-						msg: "epc error: " + err.Error(),
-					}
-					go func() { s.sendingQueue <- errmsg }()
-				} else {
-					// notify local receiver
-					_ = s.receiveReturnEpcError(Array("epc-error", sndmsg.msgID(), err.Error()))
-				}
-			} else {
-				s.debugf("SenderWoker: sent a message. [err:%v]\n", err)
-			}
-			s.debugf("SenderWoker: try next message.")
+		case <-s.sendingQueue.ready:
+			s.sendBatch()
 		}
 	}
-	s.debugf("SenderWoker: exiting...")
+	s.logger().Debug("SenderWoker: exiting")
 	s.snd2svChan <- workerClosed
-	s.debugf("SenderWoker: exited.")
+	s.logger().Debug("SenderWoker: exited")
+}
+
+// maxSendBatch is the number of the messages flushed at once at most.
+const maxSendBatch = 64
+
+// sendBatch writes the queued messages into the buffer, and flushes them
+// at once, not to make a syscall for each message in a burst.
+func (s *RPCServer) sendBatch() {
+	var batch [maxSendBatch]message
+	for {
+		n := 0
+		for n < maxSendBatch {
+			sndmsg, ok := s.sendingQueue.pop()
+			if !ok {
+				break
+			}
+			if s.debugging() {
+				s.logger().Debug("SenderWoker: pop a message", "uid", sndmsg.msgID(), "direction", "out")
+			}
+			if err := s.writeMessage(sndmsg); err != nil {
+				s.sendFailed(sndmsg, err)
+				continue
+			}
+			batch[n] = sndmsg
+			n++
+		}
+		if n == 0 {
+			return
+		}
+		if err := s.socketOut.Flush(); err != nil {
+			for _, m := range batch[:n] {
+				s.sendFailed(m, err)
+			}
+		} else if s.debugging() {
+			s.logger().Debug("SenderWoker: sent messages", "count", n)
+		}
+		batch = [maxSendBatch]message{}
+	}
+}
+
+func (s *RPCServer) sendFailed(sndmsg message, err error) {
+	s.logger().Error("SenderWoker: error", "uid", sndmsg.msgID(), "err", err)
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		// the message may be written partially
+		s.logger().Error("SenderWoker: write timeout, closing the connection")
+		_ = s.socket.Close()
+	}
+	if _, ok := sndmsg.(*messageReturn); ok {
+		// notify remote receiver, not waiting for the sender itself
+		if err := s.sendingQueue.tryPush(&messageEpcError{
+			uid: sndmsg.msgID(),
+			msg: "epc error: " + err.Error(),
+		}); err != nil {
+			s.logger().Error("could not queue a message", "uid", sndmsg.msgID(), "err", err)
+		}
+	} else {
+		// notify local receiver
+		_ = s.receiveReturnEpcError(Array("epc-error", sndmsg.msgID(), err.Error()))
+	}
+}
+
+// bodyBufPool keeps the buffers to read the message bodies. The decoded
+// messages do not refer to the buffers, so they are reused at once.
+var bodyBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 1024)
+		return &b
+	},
+}
+
+// maxPooledBodyBuf is the largest buffer put back to the pool, not to
+// keep the memory for a big message.
+const maxPooledBodyBuf = 64 * 1024
+
+func getBodyBuf(n int) *[]byte {
+	bp := bodyBufPool.Get().(*[]byte)
+	if cap(*bp) < n {
+		*bp = make([]byte, n)
+	}
+	*bp = (*bp)[:n]
+	return bp
+}
+
+func putBodyBuf(bp *[]byte) {
+	if cap(*bp) <= maxPooledBodyBuf {
+		bodyBufPool.Put(bp)
+	}
 }
 
 func (s *RPCServer) receiverWorker() {
 	lenbuf := make([]byte, 6)
-	bodybuf := make([]byte, 1024)
 	var bodyArr []interface{}
 	var uid int
 	var mtype string
@@ -453,51 +1007,54 @@ func (s *RPCServer) receiverWorker() {
 	for {
 		_, err = io.ReadFull(s.socket, lenbuf)
 		if err != nil {
-			s.debugf("ReceiverWorker: read len error :" + err.Error())
+			s.logger().Debug("ReceiverWorker: read len error", "err", err)
 			break
 		}
 		blen64, err := strconv.ParseInt(string(lenbuf), 16, 24)
 		if err != nil {
-			s.debugf("ReceiverWorker: read len error :" + err.Error())
+			s.logger().Debug("ReceiverWorker: read len error", "err", err)
 			break
 		}
-		blen := int(blen64)
-		if blen > len(bodybuf) {
-			bodybuf = make([]byte, blen)
-		} else {
-			bodybuf = bodybuf[0:blen]
-		}
-		_, err = io.ReadFull(s.socket, bodybuf)
+		bp := getBodyBuf(int(blen64))
+		_, err = io.ReadFull(s.socket, *bp)
 		if err != nil {
-			s.logger.Println("ReceiverWorker: read body error :" + err.Error())
+			putBodyBuf(bp)
+			s.logger().Error("ReceiverWorker: read body error", "err", err)
 			break
 		}
-		s.debugf("[[ %v ]]", string(bodybuf))
-		bodyObj, err := Decode1(string(bodybuf))
+		if s.debugging() {
+			s.logger().Debug("ReceiverWorker: message", "body", string(*bp), "direction", "in")
+		}
+		bodyObj, err := Decode1BytesWith(*bp, s.cfg().decodeOpts)
+		putBodyBuf(bp)
 		if err != nil {
-			s.logger.Println("ReceiverWorker: body parse error: " + err.Error())
+			s.logger().Error("ReceiverWorker: body parse error", "err", err)
 			break
 		}
 		bodyArr = ToArray(bodyObj)
 		if bodyArr == nil {
-			s.logger.Println("ReceiverWorker: invalid message: not an array.")
+			s.logger().Error("ReceiverWorker: invalid message: not an array")
 			break
 		}
 		mtype, uid, err = parseMessageHeader(bodyArr)
 		if err != nil {
-			s.logger.Println("ReceiverWorker: invalid message header: " + err.Error())
+			s.logger().Error("ReceiverWorker: invalid message header", "err", err)
 			break
 		}
 		switch mtype {
 		case "call":
-			err = s.receiveCall(bodyArr)
+			ctx, ok := s.beginCall(uid)
+			if !ok {
+				break // the running call has the uid
+			}
+			err = s.receiveCall(ctx, bodyArr)
 			if err != nil {
-				emsg := &messageEpcError{
+				s.endCall(uid)
+				s.queueReply(&messageEpcError{
 					uid: uid,
 					msg: fmt.Sprintf("epc error: %v", err),
-				}
-				s.sendingQueue <- emsg
-				s.logger.Println("ReceiverWorker: send epc-return: " + err.Error())
+				})
+				s.logger().Warn("ReceiverWorker: send epc-error", "uid", uid, "err", err)
 				err = nil
 			}
 		case "cancel":
@@ -514,20 +1071,20 @@ func (s *RPCServer) receiverWorker() {
 			err = fmt.Errorf("invalid message type: %s", mtype)
 		}
 		if err != nil {
-			s.logger.Printf("ReceiverWorker: runtime error:  uid=%d,  mtype=%s,  err=%v\n", uid, mtype, err)
+			s.logger().Warn("ReceiverWorker: runtime error", "uid", uid, "mtype", mtype, "err", err)
 			// try to continue next message
 		}
 	}
 
-	s.debugf("ReceiverWorker: exiting...")
+	s.logger().Debug("ReceiverWorker: exiting")
 	s.rcv2svChan <- workerClosed
-	s.debugf("ReceiverWorker: exited.")
+	s.logger().Debug("ReceiverWorker: exited")
 }
 
 func parseMessageHeader(bodyArr []interface{}) (mtype string, uid int, err error) {
 	var ok bool
 	err = nil
-	mtype, ok = bodyArr[0].(string)
+	mtype, ok = symbolName(bodyArr[0])
 	if !ok {
 		err = errors.New("message type is not string")
 		return
@@ -543,21 +1100,30 @@ func parseMessageHeader(bodyArr []interface{}) (mtype string, uid int, err error
 /// server functions
 
 func (s *RPCServer) IsRunning() bool {
-	return s.socketState == socketStateOpened
+	return s.state() == socketStateOpened
 }
 
 func (s *RPCServer) Stop() error {
 	if !s.IsRunning() {
 		return nil
 	}
-	s.debugf("waiting for workers shutdown: ")
+	s.logger().Debug("waiting for workers shutdown")
 	response := make(chan interface{}, 1)
-	s.user2svChan <- &serverMsg{
-		msg:      serverStop,
-		response: response,
+	select {
+	case s.user2svChan <- &serverMsg{msg: serverStop, response: response}:
+	case <-s.done:
+		return nil
 	}
-	ret := <-response
-	s.debugf("shutdown ok: %v", ret)
+	var ret interface{}
+	select {
+	case ret = <-response:
+	case <-s.done:
+		select {
+		case ret = <-response:
+		default:
+		}
+	}
+	s.logger().Debug("shutdown ok", "result", ret)
 	if ret == nil {
 		return nil
 	}
@@ -580,70 +1146,73 @@ func (s *RPCServer) WaitingSessionNum() int {
 }
 
 func (s *RPCServer) RegisterMethod(m *Method) {
-	s.methods[m.name] = m
+	s.update(func(c *config) {
+		ms := make(map[string]*Method, len(c.methods)+1)
+		for k, v := range c.methods {
+			ms[k] = v
+		}
+		ms[m.name] = m
+		c.methods = ms
+	})
 }
 
 func (s *RPCServer) Call(name string, args ...interface{}) (interface{}, error) {
-	if s.socketState != socketStateOpened {
-		return nil, fmt.Errorf("epc not connected")
-	}
-	uid := genuid()
-	msg := &messageCall{
-		uid:    uid,
-		method: name,
-		args:   args,
-	}
-	rcvChan := make(chan *methodResult, 1)
-	s.sessionMutex.Lock()
-	s.session[uid] = rcvChan
-	s.sessionMutex.Unlock()
-	s.sendingQueue <- msg
-	result := <-rcvChan
-	if !result.success {
-		return nil, result.err
-	}
-	return result.value, nil
+	return s.CallContext(context.Background(), name, args...)
 }
 
 func (s *RPCServer) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
-	if s.socketState != socketStateOpened {
+	if ics := s.cfg().clientIcs; len(ics) > 0 {
+		h := chain(ics, func(ctx context.Context, info *CallInfo) (interface{}, error) {
+			return s.callContext(ctx, info.Method, info.Args...)
+		})
+		return h(ctx, &CallInfo{Method: name, Args: args})
+	}
+	return s.callContext(ctx, name, args...)
+}
+
+func (s *RPCServer) callContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	if s.state() != socketStateOpened {
 		return nil, fmt.Errorf("epc not connected")
 	}
-	uid := genuid()
+	ctx, cancel := s.withCallTimeout(ctx)
+	defer cancel()
+	uid, rcvChan := s.newSession()
+	defer s.logCall(name, uid, time.Now())
 	msg := &messageCall{
 		uid:    uid,
 		method: name,
 		args:   args,
 	}
-	rcvChan := make(chan *methodResult, 1)
-	s.sessionMutex.Lock()
-	s.session[uid] = rcvChan
-	s.sessionMutex.Unlock()
-	s.sendingQueue <- msg
-	select {
-	case <-ctx.Done():
-		s.sendCanceling(uid)
-		return nil, fmt.Errorf("Canceled")
-	case result := <-rcvChan:
-		if !result.success {
-			return nil, result.err
-		}
-		return result.value, nil
+	if err := s.queue(ctx, msg); err != nil {
+		s.dropSession(uid)
+		return nil, err
+	}
+	result, err := s.waitResult(ctx, uid, name, rcvChan)
+	if err != nil {
+		return nil, err
 	}
+	if !result.success {
+		return nil, result.err
+	}
+	return result.value, nil
 }
 
 func (s *RPCServer) QueryMethods() ([]*MethodDesc, error) {
-	if s.socketState != socketStateOpened {
+	if s.state() != socketStateOpened {
 		return nil, fmt.Errorf("epc not connected")
 	}
-	uid := genuid()
+	ctx, cancel := s.withCallTimeout(context.Background())
+	defer cancel()
+	uid, rcvChan := s.newSession()
 	msg := &messageMethod{uid: uid}
-	rcvChan := make(chan *methodResult, 1)
-	s.sessionMutex.Lock()
-	s.session[uid] = rcvChan
-	s.sessionMutex.Unlock()
-	s.sendingQueue <- msg
-	result := <-rcvChan
+	if err := s.queue(ctx, msg); err != nil {
+		s.dropSession(uid)
+		return nil, err
+	}
+	result, err := s.waitResult(ctx, uid, "methods", rcvChan)
+	if err != nil {
+		return nil, err
+	}
 	if !result.success {
 		return nil, result.err
 	}
@@ -655,51 +1224,198 @@ func (s *RPCServer) QueryMethods() ([]*MethodDesc, error) {
 	ms := make([]*MethodDesc, len(vs))
 	for i, vv := range vs {
 		mstrs := vv.([]interface{})
+		name, _ := symbolName(mstrs[0])
+		argdoc, _ := symbolName(mstrs[1])
+		docstring, _ := symbolName(mstrs[2])
 		ms[i] = &MethodDesc{
-			Name:      mstrs[0].(string),
-			Argdoc:    mstrs[1].(string),
-			Docstring: mstrs[2].(string),
+			Name:      name,
+			Argdoc:    argdoc,
+			Docstring: docstring,
 		}
 	}
 	return ms, nil
 }
 
-func (s *RPCServer) sendCanceling(uid int) {
-	msg := &messageCancel{uid: uid}
+func (s *RPCServer) logCall(name string, uid int, start time.Time) {
+	s.logger().Debug("call done", "method", name, "uid", uid, "direction", "out", "duration", time.Since(start))
+}
+
+// withCallTimeout returns the context with the call timeout, unless the
+// context has the deadline already.
+func (s *RPCServer) withCallTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := s.cfg().callTimeout; d > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			return context.WithTimeout(ctx, d)
+		}
+	}
+	return ctx, func() {}
+}
+
+// waitResult waits for the result until the context is done. Then, it
+// sends the cancel to the peer.
+func (s *RPCServer) waitResult(ctx context.Context, uid int, name string, rcvChan chan *methodResult) (*methodResult, error) {
+	select {
+	case result := <-rcvChan:
+		return result, nil
+	case <-ctx.Done():
+		s.sendCanceling(uid)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, timeoutError(name)
+		}
+		return nil, fmt.Errorf("Canceled")
+	}
+}
+
+// symbolName returns the name of a symbol or the string itself,
+// which are decoded with or without the PreserveSymbols option.
+func symbolName(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case Symbol:
+		return string(s), true
+	case Keyword:
+		return ":" + string(s), true
+	}
+	return "", false
+}
+
+// newSession returns the next uid of this connection, and the channel
+// for the result. After the wraparound, the uids still waiting for the
+// results are skipped.
+func (s *RPCServer) newSession() (int, chan *methodResult) {
+	rcvChan := make(chan *methodResult, 1)
+	wrapped := false
 	s.sessionMutex.Lock()
+	for {
+		s.lastUID++
+		if s.lastUID > maxUID {
+			s.lastUID = 1
+			s.wrapped = true
+			wrapped = true
+		}
+		if _, ok := s.session[s.lastUID]; !ok {
+			break
+		}
+	}
+	uid := s.lastUID
+	s.session[uid] = rcvChan
+	s.sessionMutex.Unlock()
+	if wrapped {
+		s.uidEvent(UIDWraparound, uid, "call")
+	}
+	return uid, rcvChan
+}
+
+// takeSession removes the session for the reply. The replies for no
+// session are rejected.
+func (s *RPCServer) takeSession(uid int, mtype string) (chan *methodResult, error) {
+	s.sessionMutex.Lock()
+	session, ok := s.session[uid]
 	delete(s.session, uid)
+	issued := uid > 0 && (uid <= s.lastUID || s.wrapped)
 	s.sessionMutex.Unlock()
-	s.sendingQueue <- msg
+	if ok {
+		return session, nil
+	}
+	kind := UIDUnknown
+	if issued {
+		kind = UIDLate
+	}
+	s.uidEvent(kind, uid, mtype)
+	return nil, fmt.Errorf("%v: uid=%d", kind, uid)
 }
 
-func (s *RPCServer) sendMessage(m message) error {
-	msgObj, err := m.ToAst()
-	if err != nil {
-		return err
+// beginCall registers the uid of a call from the peer, and returns the
+// context canceled by the cancel message. It reports false for the uid of
+// a running call.
+func (s *RPCServer) beginCall(uid int) (context.Context, bool) {
+	var ctx context.Context
+	s.sessionMutex.Lock()
+	_, dup := s.calls[uid]
+	if !dup {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(context.Background())
+		s.calls[uid] = cancel
 	}
-	buf := msgObj.ToSExpString()
-	len := len(buf)
-	_, err = io.WriteString(s.socketOut, fmt.Sprintf("%06x", len))
-	if err != nil {
-		return err
+	s.sessionMutex.Unlock()
+	if dup {
+		s.uidEvent(UIDDuplicate, uid, "call")
 	}
-	_, err = io.WriteString(s.socketOut, buf)
-	if err != nil {
+	return ctx, !dup
+}
+
+func (s *RPCServer) endCall(uid int) {
+	s.sessionMutex.Lock()
+	cancel, ok := s.calls[uid]
+	delete(s.calls, uid)
+	s.sessionMutex.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// cancelCall cancels the context of the running call. It reports false
+// for the uid of no running call.
+func (s *RPCServer) cancelCall(uid int) bool {
+	s.sessionMutex.RLock()
+	cancel, ok := s.calls[uid]
+	s.sessionMutex.RUnlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (s *RPCServer) dropSession(uid int) {
+	s.sessionMutex.Lock()
+	delete(s.session, uid)
+	s.sessionMutex.Unlock()
+}
+
+func (s *RPCServer) sendCanceling(uid int) {
+	s.dropSession(uid)
+	s.queueReply(&messageCancel{uid: uid})
+}
+
+// maxMessageLen is the largest body written in the 6 hex digits.
+const maxMessageLen = 0xffffff
+
+// writeMessage encodes the message into the buffered writer without
+// flushing. The body is encoded into the scratch buffer of the sender
+// first, because the length is written before it.
+func (s *RPCServer) writeMessage(m message) error {
+	e := &s.sendBuf
+	e.Reset()
+	if err := m.encodeTo(e); err != nil {
+		e.ptrSeen = nil
 		return err
 	}
-	err = s.socketOut.Flush()
-	if err != nil {
+	n := e.Len()
+	if n > maxMessageLen {
+		return &EncodeError{Msg: fmt.Sprintf("message too large: %d bytes", n)}
+	}
+	if d := s.cfg().writeTimeout; d > 0 {
+		_ = s.socket.SetWriteDeadline(time.Now().Add(d))
+	}
+	var head [6]byte
+	for i := len(head) - 1; i >= 0; i-- {
+		head[i] = "0123456789abcdef"[n&0xf]
+		n >>= 4
+	}
+	if _, err := s.socketOut.Write(head[:]); err != nil {
 		return err
 	}
-	return nil
+	_, err := s.socketOut.Write(e.Bytes())
+	return err
 }
 
-func (s *RPCServer) receiveCall(bodyArr []interface{}) (err error) {
+func (s *RPCServer) receiveCall(ctx context.Context, bodyArr []interface{}) (err error) {
 	uid, ok := bodyArr[1].(int)
 	if !ok {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
-	name, ok := bodyArr[2].(string)
+	name, ok := symbolName(bodyArr[2])
 	if !ok {
 		return fmt.Errorf("method name is not string [%v]", bodyArr[2])
 	}
@@ -711,8 +1427,9 @@ func (s *RPCServer) receiveCall(bodyArr []interface{}) (err error) {
 		return fmt.Errorf("arguments object is not list [%v, %v]", bodyArr[3], argsv.Kind().String())
 	}
 
-	s.debugf(": called: name=%s : uid=%d", name, uid)
-	method, ok := s.methods[name]
+	s.logger().Debug("called", "method", name, "uid", uid, "direction", "in")
+	c := s.cfg()
+	method, ok := c.methods[name]
 	if !ok {
 		return fmt.Errorf("method not found: name=%s", name)
 	}
@@ -730,12 +1447,17 @@ func (s *RPCServer) receiveCall(bodyArr []interface{}) (err error) {
 		return fmt.Errorf("different argument length: expected %d, but received %d",
 			len(method.argTypes), argsvlen)
 	}
-	s.debugf(": extracting arguments: %v", argsvlen)
+	debugging := s.debugging()
+	if debugging {
+		s.logger().Debug("extracting arguments", "method", name, "uid", uid, "count", argsvlen)
+	}
 	argv := make([]reflect.Value, argsvlen)
 	for i := 0; i < argsvlen; i++ {
 		av := reflect.ValueOf(argsv.Index(i).Interface())
 		it := method.argTypes[i]
-		s.debugf("   : %v : %v -> %v", av.Interface(), av.Type().Kind(), it.Kind())
+		if debugging {
+			s.logger().Debug("argument", "value", av.Interface(), "from", av.Type().Kind(), "to", it.Kind())
+		}
 		if av.Type().Kind() != it.Kind() {
 			av, err = ConvertType(it, av)
 			if err != nil {
@@ -746,57 +1468,140 @@ func (s *RPCServer) receiveCall(bodyArr []interface{}) (err error) {
 			if err != nil {
 				return fmt.Errorf("can not convert type: [%v] : type[%v] -> type[%v]", av, av.Type().String(), it.String())
 			}
+		} else if av.Type() != it && av.Type().ConvertibleTo(it) {
+			av = av.Convert(it) // ex: Symbol -> string
 		}
 		argv[i] = av
 	}
 
 	// execute function
+	global, interceptors := c.limiter, c.interceptors
 	go func() {
+		start := time.Now()
+		// the first of the result, the timeout and the panic is replied,
+		// and the canceled call is not
+		var replied int32
+		canceled := func() bool {
+			if !errors.Is(ctx.Err(), context.Canceled) {
+				return false
+			}
+			if atomic.CompareAndSwapInt32(&replied, 0, 1) {
+				s.endCall(uid)
+				s.logger().Debug("the call was canceled", "method", name, "uid", uid, "duration", time.Since(start))
+			}
+			return true
+		}
+		// the method first, not to hold a global slot waiting for it
+		if !method.limiter.acquire(ctx) {
+			if !canceled() {
+				s.rejectCall(name, uid)
+			}
+			return
+		}
+		defer method.limiter.release()
+		if !global.acquire(ctx) {
+			if !canceled() {
+				s.rejectCall(name, uid)
+			}
+			return
+		}
+		defer global.release()
+
+		if method.timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, method.timeout)
+			defer cancelTimeout()
+		}
+		reply := func(m message) bool {
+			if !atomic.CompareAndSwapInt32(&replied, 0, 1) {
+				return false
+			}
+			s.endCall(uid)
+			if err := s.queue(context.Background(), m); err != nil {
+				s.logger().Error("could not send the reply", "method", name, "uid", uid, "err", err)
+			}
+			return true
+		}
+		timedOut := func() bool {
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return false
+			}
+			if reply(&messageEpcError{
+				uid:  uid,
+				msg:  fmt.Sprintf("epc error: %v", timeoutError(name)),
+				code: "call-timeout",
+			}) {
+				s.logger().Warn("the call timed out", "method", name, "uid", uid, "duration", time.Since(start))
+			}
+			return true
+		}
+		if method.timeout > 0 {
+			stop := context.AfterFunc(ctx, func() { timedOut() })
+			defer stop()
+		}
 		defer func() {
 			rr := recover()
 			if rr != nil {
-				emsg := &messageError{
+				reply(&messageError{
 					uid: uid,
 					msg: fmt.Sprintf("Go error: %v", rr),
-				}
-				s.sendingQueue <- emsg
-				s.debugf(": executing DONE ERROR: name=%s : uid=%d , error=%v", name, uid, rr)
+				})
+				s.logger().Debug("executing done with panic", "method", name, "uid", uid, "panic", rr, "duration", time.Since(start))
 			}
 		}()
 
-		s.debugf(": executing: name=%s : uid=%d", name, uid)
-		retv := method.mfunc.Call(argv)
-		var vv interface{}
-		if len(retv) == 0 {
-			vv = nil
-		} else {
-			vv = retv[0].Interface()
-		}
-		rmsg := &messageReturn{uid: uid, value: vv}
-		s.sendingQueue <- rmsg
-		s.debugf(": executing DONE: name=%s : uid=%d", name, uid)
+		s.logger().Debug("executing", "method", name, "uid", uid)
+		info := &CallInfo{Method: name, UID: uid, Args: make([]interface{}, len(argv))}
+		for i, av := range argv {
+			info.Args[i] = av.Interface()
+		}
+		h := chain(interceptors, func(ctx context.Context, info *CallInfo) (interface{}, error) {
+			return method.invoke(ctx, info.Args), nil
+		})
+		vv, err := h(ctx, info)
+		if timedOut() || canceled() {
+			return
+		}
+		if err != nil {
+			reply(&messageError{uid: uid, msg: err.Error()})
+			s.logger().Debug("executing done with error", "method", name, "uid", uid, "err", err, "duration", time.Since(start))
+			return
+		}
+		if !reply(&messageReturn{uid: uid, value: vv}) {
+			s.logger().Debug("discard the result after the timeout", "method", name, "uid", uid, "duration", time.Since(start))
+			return
+		}
+		s.logger().Debug("executing done", "method", name, "uid", uid, "duration", time.Since(start))
 	}()
 
 	return nil
 }
 
+func (s *RPCServer) rejectCall(name string, uid int) {
+	s.endCall(uid)
+	s.logger().Warn("reject the call", "method", name, "uid", uid)
+	emsg := &messageEpcError{
+		uid:  uid,
+		msg:  fmt.Sprintf("epc error: %v: name=%s", ErrTooManyCalls, name),
+		code: "too-many-calls",
+	}
+	if err := s.queue(context.Background(), emsg); err != nil {
+		s.logger().Error("could not send the error", "method", name, "uid", uid, "err", err)
+	}
+}
+
 func (s *RPCServer) receiveReturn(bodyArr []interface{}) (err error) {
 	uid, ok := bodyArr[1].(int)
 	if !ok {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
 	value := bodyArr[2]
-	s.debugf(": returned: uid=%d", uid)
+	s.logger().Debug("returned", "uid", uid, "direction", "in")
 
-	s.sessionMutex.RLock()
-	session, ok := s.session[uid]
-	s.sessionMutex.RUnlock()
-	if !ok {
-		return fmt.Errorf("not found a session for uid=%d", uid)
+	session, err := s.takeSession(uid, "return")
+	if err != nil {
+		return err
 	}
-	s.sessionMutex.Lock()
-	delete(s.session, uid)
-	s.sessionMutex.Unlock()
 	mresult := &methodResult{
 		success: true,
 		value:   value,
@@ -814,17 +1619,12 @@ func (s *RPCServer) receiveReturnError(bodyArr []interface{}) (err error) {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
 	errval := bodyArr[2]
-	s.debugf(": returned error: uid=%d  error=%v", uid, errval)
+	s.logger().Debug("returned error", "uid", uid, "direction", "in", "err", errval)
 
-	s.sessionMutex.RLock()
-	session, ok := s.session[uid]
-	s.sessionMutex.RUnlock()
-	if !ok {
-		return fmt.Errorf("not found a session for uid=%d", uid)
+	session, err := s.takeSession(uid, "return-error")
+	if err != nil {
+		return err
 	}
-	s.sessionMutex.Lock()
-	delete(s.session, uid)
-	s.sessionMutex.Unlock()
 	mresult := &methodResult{
 		success: false,
 		value:   nil,
@@ -842,21 +1642,16 @@ func (s *RPCServer) receiveReturnEpcError(bodyArr []interface{}) (err error) {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
 	errval := bodyArr[2]
-	s.debugf(": returned epc-error: uid=%d  error=%v", uid, errval)
+	s.logger().Debug("returned epc-error", "uid", uid, "direction", "in", "err", errval)
 
-	s.sessionMutex.RLock()
-	session, ok := s.session[uid]
-	s.sessionMutex.RUnlock()
-	if !ok {
-		return fmt.Errorf("not found a session for uid=%d", uid)
+	session, err := s.takeSession(uid, "epc-error")
+	if err != nil {
+		return err
 	}
-	s.sessionMutex.Lock()
-	delete(s.session, uid)
-	s.sessionMutex.Unlock()
 	mresult := &methodResult{
 		success: false,
 		value:   nil,
-		err:     fmt.Errorf("%v", errval),
+		err:     newEpcError(errval),
 	}
 	go func() {
 		session <- mresult
@@ -864,17 +1659,45 @@ func (s *RPCServer) receiveReturnEpcError(bodyArr []interface{}) (err error) {
 	return nil
 }
 
+// epcError is an epc-error from the peer. The errors with the codes
+// unwrap to the reasons, such as ErrCallTimeout.
+type epcError struct {
+	msg    string
+	reason error
+}
+
+// newEpcError makes the error of the payload, the message optionally
+// tagged with the code as "[code] message".
+func newEpcError(payload interface{}) *epcError {
+	msg := fmt.Sprint(payload)
+	if rest, ok := strings.CutPrefix(msg, "["); ok {
+		if code, body, ok := strings.Cut(rest, "] "); ok {
+			if reason, ok := epcErrorCodes[code]; ok {
+				return &epcError{msg: body, reason: reason}
+			}
+		}
+	}
+	return &epcError{msg: msg}
+}
+
+func (e *epcError) Error() string {
+	return e.msg
+}
+
+func (e *epcError) Unwrap() error {
+	return e.reason
+}
+
 func (s *RPCServer) receiveCancel(bodyArr []interface{}) (err error) {
 	uid, ok := bodyArr[1].(int)
 	if !ok {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
-	s.debugf(": cancel: uid=%d", uid)
-	// TODO Cancel
-	// check handler function arguments have a context
-	// hold the context for uid
-	// send cancel event to the context
-	// cleanup session info
+	s.logger().Debug("cancel", "uid", uid, "direction", "in")
+	// the call may be done already
+	if !s.cancelCall(uid) {
+		s.logger().Debug("no running call to cancel", "uid", uid)
+	}
 	return nil
 }
 
@@ -883,11 +1706,12 @@ func (s *RPCServer) receiveMethods(bodyArr []interface{}) (err error) {
 	if !ok {
 		return fmt.Errorf("uid is not int [%v]", bodyArr[1])
 	}
-	s.debugf(": query-methods: uid=%d", uid)
+	s.logger().Debug("query-methods", "uid", uid, "direction", "in")
 
-	result := make([][]string, len(s.methods))
+	methods := s.cfg().methods
+	result := make([][]string, len(methods))
 	idx := 0
-	for _, m := range s.methods {
+	for _, m := range methods {
 		result[idx] = []string{
 			m.name, m.argdoc, m.docstring,
 		}
@@ -898,8 +1722,8 @@ func (s *RPCServer) receiveMethods(bodyArr []interface{}) (err error) {
 		uid:   uid,
 		value: result,
 	}
-	s.sendingQueue <- rmsg
-	s.debugf(": query-methods DONE: uid=%d", uid)
+	s.queueReply(rmsg)
+	s.logger().Debug("query-methods done", "uid", uid)
 
 	return nil
 }
@@ -1,13 +1,22 @@
 package elrpc
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/k0kubun/pp"
+	"github.com/kiwanami/go-elrpc/parser"
 )
 
 func TestRpcServerStop(t *testing.T) {
@@ -52,13 +61,12 @@ func TestRpcEcho(t *testing.T) {
 	n, _ := mockConn.GetWriter(buf)
 	sbuf := buf[6:n]
 	callmsg := string(sbuf)
-	if callmsg != fmt.Sprintf("(call %d \"echo\" (\"test1\"))", uidCounter.count) {
+	if callmsg != "(call 1 \"echo\" (\"test1\"))" { // the first uid of the connection
 		pp.Println(callmsg)
 		t.Error("Could not pass the echo call message.")
 	}
 	// check receive message
-	cc := uidCounter.count
-	body := fmt.Sprintf("(return %d \"test1\")", cc)
+	body := "(return 1 \"test1\")"
 	msg := fmt.Sprintf("%06x%s", len(body), body)
 	mockConn.PushReader([]byte(msg))
 	ret := <-wt
@@ -80,7 +88,7 @@ func TestRpcEcho2(t *testing.T) {
 	defer server.Stop()
 	time.Sleep(50 * time.Millisecond)
 
-	cc := genuid()
+	cc := nextPeerUID()
 	body := fmt.Sprintf("(call %d \"echo\" (\"test2\"))", cc)
 	msg := fmt.Sprintf("%06x%s", len(body), body)
 	buf := []byte(msg)
@@ -91,13 +99,13 @@ func TestRpcEcho2(t *testing.T) {
 	sbuf := buf[6:n]
 	ret := string(sbuf)
 	//pp.Println(ret)
-	if ret != fmt.Sprintf("(return %d \"echo:test2\")", uidCounter.count) {
+	if ret != fmt.Sprintf("(return %d \"echo:test2\")", cc) {
 		t.Errorf("Could not pass the echo message: %v", ret)
 	}
 }
 
 func testErrorReturn(t *testing.T, conn *mockConn, name string, args interface{}, expectedf string) {
-	cc := genuid()
+	cc := nextPeerUID()
 	body := fmt.Sprintf("(call %d \"%s\" (%v))", cc, name, args)
 	msg := fmt.Sprintf("%06x%s", len(body), body)
 	buf := []byte(msg)
@@ -108,7 +116,7 @@ func testErrorReturn(t *testing.T, conn *mockConn, name string, args interface{}
 	sbuf := buf[6:n]
 	ret := string(sbuf)
 	//pp.Println(ret)
-	if ret != fmt.Sprintf(expectedf, uidCounter.count) {
+	if ret != fmt.Sprintf(expectedf, cc) {
 		t.Errorf("Could not check the error: expected:[%v]  returned:[%v]", expectedf, ret)
 	}
 }
@@ -162,7 +170,7 @@ func TestRpcMethods1(t *testing.T) {
 	defer server.Stop()
 	time.Sleep(50 * time.Millisecond)
 
-	cc := genuid()
+	cc := nextPeerUID()
 	body := fmt.Sprintf("(methods %d)", cc)
 	msg := fmt.Sprintf("%06x%s", len(body), body)
 	buf := []byte(msg)
@@ -175,11 +183,485 @@ func TestRpcMethods1(t *testing.T) {
 	//pp.Println(ret)
 	exp := Array(Array("echo", "argdoc", "docstring"))
 	expstr, _ := Encode(exp)
-	if ret != fmt.Sprintf("(return %d %s)", uidCounter.count, expstr) {
+	if ret != fmt.Sprintf("(return %d %s)", cc, expstr) {
 		t.Errorf("Could not pass the echo message: %v", ret)
 	}
 }
 
+func TestRpcSymbols1(t *testing.T) {
+	mockConn := makeMockConn()
+	ms := []*Method{
+		MakeMethod("mode", func(name string, mode interface{}) interface{} {
+			return []interface{}{name, mode, Keyword("success")}
+		}, "", ""),
+	}
+	server := makeRPCServer("Symbols1", mockConn, ms)
+	//server.SetDebug(true)
+	server.SetDecodeOptions(&parser.ValueOptions{PreserveSymbols: true})
+	defer server.Stop()
+	time.Sleep(50 * time.Millisecond)
+
+	cc := nextPeerUID()
+	body := fmt.Sprintf("(call %d mode (foo-mode emacs-lisp-mode))", cc)
+	msg := fmt.Sprintf("%06x%s", len(body), body)
+	mockConn.PushReader([]byte(msg))
+
+	buf := make([]byte, 1024)
+	n, _ := mockConn.GetWriter(buf)
+	ret := string(buf[6:n])
+	if ret != fmt.Sprintf("(return %d (\"foo-mode\" emacs-lisp-mode :success))", cc) {
+		t.Errorf("Could not pass the symbols: %v", ret)
+	}
+}
+
+func TestRpcUIDs(t *testing.T) {
+	mockConn := makeMockConn()
+	release := make(chan struct{})
+	ms := []*Method{
+		MakeMethod("wait", func() int {
+			<-release
+			return 1
+		}, "", ""),
+	}
+	server := makeRPCServer("UIDs", mockConn, ms)
+	defer server.Stop()
+	events := make(chan UIDEvent, 10)
+	server.SetUIDEventHandler(func(e UIDEvent) { events <- e })
+
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+	pull := func() string { return readMessage(mockConn) }
+	expect := func(kind UIDEventKind, uid int) {
+		select {
+		case e := <-events:
+			if e.Kind != kind || e.UID != uid {
+				t.Errorf("Unexpected event: %v %d, expected: %v %d", e.Kind, e.UID, kind, uid)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("No event: %v %d", kind, uid)
+		}
+	}
+	call := func(uid int) {
+		done := make(chan interface{}, 1)
+		go func() {
+			ret, _ := server.Call("echo", 10)
+			done <- ret
+		}()
+		if msg := pull(); !strings.HasPrefix(msg, fmt.Sprintf("(call %d ", uid)) {
+			t.Errorf("Unexpected call: %s", msg)
+		}
+		push(fmt.Sprintf("(return %d 10)", uid))
+		if ret := <-done; ret != 10 {
+			t.Errorf("Unexpected return: %v", ret)
+		}
+	}
+
+	call(1) // the uids start from 1 for each connection
+	push("(return 1 10)")
+	expect(UIDLate, 1)
+	push("(return 100 10)")
+	expect(UIDUnknown, 100)
+
+	// the second call with the running uid is dropped
+	push("(call 7 wait nil)")
+	push("(call 7 wait nil)")
+	expect(UIDDuplicate, 7)
+	close(release)
+	if msg := pull(); msg != "(return 7 1)" {
+		t.Errorf("Unexpected return: %s", msg)
+	}
+
+	// the uid of the waiting session is skipped after the wraparound
+	server.sessionMutex.Lock()
+	server.lastUID = maxUID
+	server.session[1] = make(chan *methodResult, 1)
+	server.sessionMutex.Unlock()
+	call(2)
+	expect(UIDWraparound, 2)
+}
+
+func TestRpcConcurrencyLimit(t *testing.T) {
+	mockConn := makeMockConn()
+	gate := make(chan struct{})
+	var running, maxRunning int32
+	ms := []*Method{
+		MakeMethod("serial", func() int {
+			n := atomic.AddInt32(&running, 1)
+			if n > atomic.LoadInt32(&maxRunning) {
+				atomic.StoreInt32(&maxRunning, n)
+			}
+			<-gate
+			atomic.AddInt32(&running, -1)
+			return 1
+		}, "", "").Serialize(),
+		MakeMethod("block", func() int {
+			<-gate
+			return 2
+		}, "", ""),
+	}
+	server := makeRPCServer("ConcurrencyLimit", mockConn, ms)
+	defer server.Stop()
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+	pull := func() string { return readMessage(mockConn) }
+
+	// the serialized calls wait for the running one
+	for i := 1; i <= 3; i++ {
+		push(fmt.Sprintf("(call %d serial nil)", i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	for i := 1; i <= 3; i++ {
+		if msg := pull(); !strings.HasPrefix(msg, "(return ") {
+			t.Errorf("Unexpected reply: %s", msg)
+		}
+	}
+	if n := atomic.LoadInt32(&maxRunning); n != 1 {
+		t.Errorf("Serialized calls overlapped: %d", n)
+	}
+
+	// the calls over the global limit are rejected
+	gate = make(chan struct{})
+	server.SetConcurrencyLimit(ConcurrencyLimit{Max: 1, Reject: true})
+	push("(call 10 block nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(call 11 block nil)")
+	if msg := pull(); msg != `(epc-error 11 "[too-many-calls] epc error: too many calls: name=block")` {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	close(gate)
+	if msg := pull(); msg != "(return 10 2)" {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+
+	// the calls over the pending queue are rejected
+	gate = make(chan struct{})
+	server.SetConcurrencyLimit(ConcurrencyLimit{Max: 1, MaxPending: 1})
+	push("(call 20 block nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(call 21 block nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(call 22 block nil)")
+	if msg := pull(); msg != `(epc-error 22 "[too-many-calls] epc error: too many calls: name=block")` {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	close(gate)
+	for _, uid := range []int{20, 21} {
+		if msg := pull(); msg != fmt.Sprintf("(return %d 2)", uid) {
+			t.Errorf("Unexpected reply: %s", msg)
+		}
+	}
+}
+
+func TestRpcTimeout(t *testing.T) {
+	mockConn := makeMockConn()
+	cancelled := make(chan error, 1)
+	ms := []*Method{
+		MakeMethod("sleepy", func(ctx context.Context, n int) int {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return n
+		}, "", "").SetTimeout(50 * time.Millisecond),
+		MakeMethod("quick", func(n int) int { return n }, "", ""),
+	}
+	server := makeRPCServer("Timeout", mockConn, ms)
+	defer server.Stop()
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+	pull := func() string { return readMessage(mockConn) }
+
+	// the handler over the deadline
+	push("(call 1 sleepy (10))")
+	if msg := pull(); msg != `(epc-error 1 "[call-timeout] epc error: call timeout: name=sleepy")` {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	if err := <-cancelled; err != context.DeadlineExceeded {
+		t.Errorf("Context not cancelled by the deadline: %v", err)
+	}
+	push("(call 2 quick (20))")
+	if msg := pull(); msg != "(return 2 20)" { // the result of sleepy is discarded
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+
+	// the call to the peer over the call timeout
+	server.SetCallTimeout(50 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Call("echo", 1)
+		done <- err
+	}()
+	if msg := pull(); !strings.HasPrefix(msg, "(call 1 ") {
+		t.Errorf("Unexpected call: %s", msg)
+	}
+	if err := <-done; !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Expected a timeout error: %v", err)
+	}
+	if msg := pull(); msg != "(cancel 1)" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+
+	// the timeout on the peer
+	server.SetCallTimeout(0)
+	go func() {
+		_, err := server.Call("echo", 2)
+		done <- err
+	}()
+	pull()
+	push(`(epc-error 2 "[call-timeout] epc error: call timeout: name=echo")`)
+	if err := <-done; !errors.Is(err, ErrCallTimeout) || err.Error() != "epc error: call timeout: name=echo" {
+		t.Errorf("Expected a timeout error: %v", err)
+	}
+	go func() {
+		_, err := server.Call("echo", 3)
+		done <- err
+	}()
+	pull()
+	push(`(epc-error 3 "epc error: [call-timeout] call timeout")`) // not tagged at the head
+	if err := <-done; err == nil || errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Unexpected timeout error: %v", err)
+	}
+	if n := server.WaitingSessionNum(); n != 0 {
+		t.Errorf("Sessions remain: %d", n)
+	}
+}
+
+func TestRpcCancel(t *testing.T) {
+	mockConn := makeMockConn()
+	cancelled := make(chan error, 1)
+	gate := make(chan struct{})
+	ms := []*Method{
+		MakeMethod("wait", func(ctx context.Context) int {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return 1
+		}, "", ""),
+		MakeMethod("block", func() int {
+			<-gate
+			return 2
+		}, "", "").Serialize(),
+		MakeMethod("quick", func(n int) int { return n }, "", ""),
+	}
+	server := makeRPCServer("Cancel", mockConn, ms)
+	defer server.Stop()
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+	pull := func() string { return readMessage(mockConn) }
+
+	// the running call
+	push("(call 1 wait nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(cancel 1)")
+	if err := <-cancelled; err != context.Canceled {
+		t.Errorf("Context not cancelled: %v", err)
+	}
+	push("(call 2 quick (20))")
+	if msg := pull(); msg != "(return 2 20)" { // the canceled call is not replied
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+
+	// the call waiting for the limit
+	push("(call 3 block nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(call 4 block nil)")
+	time.Sleep(50 * time.Millisecond)
+	push("(cancel 4)")
+	push("(cancel 100)") // no running call
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	if msg := pull(); msg != "(return 3 2)" {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	push("(call 5 quick (50))")
+	if msg := pull(); msg != "(return 5 50)" {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	server.sessionMutex.RLock()
+	n := len(server.calls)
+	server.sessionMutex.RUnlock()
+	if n != 0 {
+		t.Errorf("Running calls remain: %d", n)
+	}
+}
+
+func TestRpcInterceptors(t *testing.T) {
+	mockConn := makeMockConn()
+	ms := []*Method{
+		MakeMethod("add", func(a, b int) int { return a + b }, "", ""),
+		MakeMethod("secret", func() int { return 0 }, "", ""),
+		MakeMethod("boom", func() int { panic("x") }, "", ""),
+	}
+	server := makeRPCServer("Interceptors", mockConn, ms)
+	defer server.Stop()
+	var order []string
+	server.SetInterceptors(
+		func(ctx context.Context, info *CallInfo, next Handler) (ret interface{}, err error) {
+			order = append(order, "recover:"+info.Method)
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("recovered: %v", r)
+				}
+			}()
+			return next(ctx, info)
+		},
+		func(ctx context.Context, info *CallInfo, next Handler) (interface{}, error) {
+			order = append(order, "auth:"+info.Method)
+			if info.Method == "secret" {
+				return nil, errors.New("forbidden")
+			}
+			if info.Method == "add" {
+				info.Args[0] = 10
+			}
+			return next(ctx, info)
+		},
+	)
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+	pull := func() string { return readMessage(mockConn) }
+
+	push("(call 1 add (1 2))")
+	if msg := pull(); msg != "(return 1 12)" {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	push("(call 2 secret nil)")
+	if msg := pull(); msg != `(return-error 2 "forbidden")` {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	push("(call 3 boom nil)")
+	if msg := pull(); msg != `(return-error 3 "recovered: x")` {
+		t.Errorf("Unexpected reply: %s", msg)
+	}
+	expected := "recover:add auth:add recover:secret auth:secret recover:boom auth:boom"
+	if s := strings.Join(order, " "); s != expected {
+		t.Errorf("Unexpected order: %s", s)
+	}
+
+	server.SetClientInterceptors(func(ctx context.Context, info *CallInfo, next Handler) (interface{}, error) {
+		info.Args = append(info.Args, "added")
+		ret, err := next(ctx, info)
+		return fmt.Sprintf("%s:%v", info.Method, ret), err
+	})
+	done := make(chan interface{}, 1)
+	go func() {
+		ret, _ := server.Call("echo", 1)
+		done <- ret
+	}()
+	if msg := pull(); msg != `(call 1 "echo" (1 "added"))` {
+		t.Errorf("Unexpected call: %s", msg)
+	}
+	push("(return 1 2)")
+	if ret := <-done; ret != "echo:2" {
+		t.Errorf("Unexpected return: %v", ret)
+	}
+
+	// the context of the interceptor reaches the call
+	server.SetClientInterceptors(func(ctx context.Context, info *CallInfo, next Handler) (interface{}, error) {
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		return next(ctx, info)
+	})
+	errc := make(chan error, 1)
+	go func() {
+		_, err := server.Call("echo", 1)
+		errc <- err
+	}()
+	if msg := pull(); msg != `(call 2 "echo" (1))` {
+		t.Errorf("Unexpected call: %s", msg)
+	}
+	if msg := pull(); msg != "(cancel 2)" {
+		t.Errorf("Unexpected cancel: %s", msg)
+	}
+	if err := <-errc; !errors.Is(err, ErrCallTimeout) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// syncBuffer is a buffer written by the goroutines of the server.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRpcLogger(t *testing.T) {
+	mockConn := makeMockConn()
+	ms := []*Method{
+		MakeMethod("echo", func(s string) string { return s }, "", ""),
+	}
+	server := makeRPCServer("Logger", mockConn, ms)
+	defer server.Stop()
+	var out syncBuffer
+	server.SetLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	push := func(body string) {
+		mockConn.PushReader([]byte(fmt.Sprintf("%06x%s", len(body), body)))
+	}
+
+	push(`(call 1 echo ("a"))`)
+	readMessage(mockConn)
+	push("(return 100 nil)")
+	time.Sleep(50 * time.Millisecond)
+	logs := out.String()
+	if strings.Contains(logs, `"level":"DEBUG"`) {
+		t.Errorf("Debug logs at the info level: %s", logs)
+	}
+	if !strings.Contains(logs, `"msg":"uid rejected","conn":"Logger","event":"unknown uid","uid":100`) {
+		t.Errorf("Warning not logged: %s", logs)
+	}
+
+	server.SetDebug(true)
+	push(`(call 2 echo ("b"))`)
+	readMessage(mockConn)
+	time.Sleep(50 * time.Millisecond)
+	logs = out.String()
+	for _, attr := range []string{
+		`"msg":"ReceiverWorker: message","conn":"Logger","body":"(call 2 echo (\"b\"))"`,
+		`"msg":"called","conn":"Logger","method":"echo","uid":2,"direction":"in"`,
+		`"msg":"executing done","conn":"Logger","method":"echo","uid":2,"duration":`,
+	} {
+		if !strings.Contains(logs, attr) {
+			t.Errorf("Not logged: %s in %s", attr, logs)
+		}
+	}
+}
+
+// readMessage reads a message sent to the mock peer. The messages may
+// be written at once.
+func readMessage(conn *mockConn) string {
+	var lenbuf [6]byte
+	if _, err := io.ReadFull(conn.clientReader, lenbuf[:]); err != nil {
+		return err.Error()
+	}
+	n, _ := strconv.ParseInt(string(lenbuf[:]), 16, 32)
+	body := make([]byte, n)
+	if _, err := io.ReadFull(conn.clientReader, body); err != nil {
+		return err.Error()
+	}
+	return string(body)
+}
+
+// nextPeerUID returns the uids of the calls from the mock peer.
+func nextPeerUID() int {
+	peerUID++
+	return peerUID
+}
+
+var peerUID int
+
 /// socket mock
 
 type mockConn struct {
@@ -231,3 +713,125 @@ func (m *mockConn) RemoteAddr() net.Addr               { return nil }
 func (m *mockConn) SetDeadline(t time.Time) error      { return nil }
 func (m *mockConn) SetReadDeadline(t time.Time) error  { return nil }
 func (m *mockConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func TestRpcSendQueueFull(t *testing.T) {
+	mockConn := makeMockConn() // nobody reads the written messages
+	server := makeRPCServer("QueueFull", mockConn, nil)
+	defer server.Stop()
+	server.SetSendQueueSize(1)
+	server.SetWriteTimeout(50 * time.Millisecond) // no deadline on the mock
+
+	// the first is blocked in writing, and the second fills the queue
+	go func() { _, _ = server.Call("echo", "1") }()
+	time.Sleep(30 * time.Millisecond)
+	go func() { _, _ = server.Call("echo", "2") }()
+	time.Sleep(30 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := server.CallContext(ctx, "echo", "3")
+	if !errors.Is(err, ErrSendQueueFull) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the queue full error: %v", err)
+	}
+	start := time.Now()
+	_, err = server.Call("echo", "4")
+	if !errors.Is(err, ErrSendQueueFull) || time.Since(start) > time.Second {
+		t.Errorf("Expected the queue full error: %v", err)
+	}
+	if n := server.WaitingSessionNum(); n != 2 {
+		t.Errorf("Sessions of the failed calls remain: %d", n)
+	}
+	// without the write timeout, the call fails at once
+	server.SetWriteTimeout(0)
+	start = time.Now()
+	_, err = server.Call("echo", "5")
+	if !errors.Is(err, ErrSendQueueFull) || time.Since(start) > 100*time.Millisecond {
+		t.Errorf("Expected the queue full error at once: %v", err)
+	}
+}
+
+func TestRpcWriteTimeout(t *testing.T) {
+	conn, peer := net.Pipe() // the peer does not read
+	defer peer.Close()
+	server := makeRPCServer("WriteTimeout", conn, nil)
+	defer server.Stop()
+	server.SetWriteTimeout(50 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Call("echo", "test")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Errorf("Expected a timeout error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Call hung on a stalled peer")
+	}
+	// the closed connection stops once
+	stopped := make(chan struct{})
+	go func() {
+		_ = server.Stop()
+		server.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(20 * time.Second):
+		t.Fatal("Server did not stop after the write timeout")
+	}
+	if server.IsRunning() {
+		t.Error("Server still running")
+	}
+}
+
+// countingConn counts the writes, the syscalls of the sender.
+type countingConn struct {
+	net.Conn
+	writes int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.Conn.Write(b)
+}
+
+func BenchmarkRpcParallelCalls(b *testing.B) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	cconn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	sconn := &countingConn{Conn: <-accepted}
+	echo := MakeMethod("echo", func(s string) string { return s }, "", "")
+	server := makeRPCServer("BenchServer", sconn, []*Method{echo})
+	defer server.Stop()
+	client := makeRPCServer("BenchClient", &countingConn{Conn: cconn}, nil)
+	defer client.Stop()
+	client.SetSendQueueSize(1024)
+
+	b.SetParallelism(64)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := client.Call("echo", "hello"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&sconn.writes))/float64(b.N), "writes/return")
+	b.ReportMetric(float64(atomic.LoadInt64(&client.socket.(*countingConn).writes))/float64(b.N), "writes/call")
+}
@@ -0,0 +1,250 @@
+package elrpc
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+// Template is a backquoted form whose placeholders, ,name and ,@name,
+// are filled with Go values. The values are encoded into S-expressions,
+// so that the strings can not break the generated code:
+//
+//	t, _ := NewTemplate("`(message \"Hello %s\" ,name)")
+//	s, _ := t.ExpandString(map[string]interface{}{"name": `"); (kill-emacs`})
+//
+// As the backquote of Emacs Lisp, the values are inserted as they are.
+// Quote the placeholder to insert a list as data, such as ',items.
+type Template struct {
+	sexp parser.SExp
+}
+
+// NewTemplate parses the template. The outer backquote may be omitted.
+func NewTemplate(src string) (*Template, error) {
+	sexps, err := parser.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(sexps) != 1 {
+		return nil, fmt.Errorf("template should be one S-expression: %d", len(sexps))
+	}
+	s := sexps[0]
+	if qq, ok := s.(*parser.SExpQuasiQuoted); ok {
+		s = qq.Inner()
+	}
+	return &Template{sexp: s}, nil
+}
+
+// Expand returns a new S-expression filled with the values of the map
+// or the fields of the struct. The field names are matched without
+// case, so that ,name refers to the Name field. A parser.SExp value is
+// inserted without encoding.
+func (t *Template) Expand(values interface{}) (parser.SExp, error) {
+	x := &expander{values: reflect.ValueOf(values)}
+	for x.values.Kind() == reflect.Ptr || x.values.Kind() == reflect.Interface {
+		x.values = x.values.Elem()
+	}
+	switch x.values.Kind() {
+	case reflect.Map:
+		if x.values.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("template values should have string keys: %v", x.values.Type())
+		}
+	case reflect.Struct, reflect.Invalid:
+	default:
+		return nil, fmt.Errorf("template values should be a map or a struct: %v", x.values.Type())
+	}
+	return x.expand(t.sexp, 1)
+}
+
+// ExpandString returns the expanded S-expression as a string.
+func (t *Template) ExpandString(values interface{}) (string, error) {
+	s, err := t.Expand(values)
+	if err != nil {
+		return "", err
+	}
+	return s.ToSExpString(), nil
+}
+
+type expander struct {
+	values reflect.Value
+}
+
+func (x *expander) lookup(name string) (interface{}, error) {
+	var v reflect.Value
+	switch x.values.Kind() {
+	case reflect.Map:
+		v = x.values.MapIndex(reflect.ValueOf(name).Convert(x.values.Type().Key()))
+	case reflect.Struct:
+		v = x.values.FieldByNameFunc(func(n string) bool {
+			return strings.EqualFold(n, name)
+		})
+		if v.IsValid() && !v.CanInterface() {
+			v = reflect.Value{}
+		}
+	}
+	if !v.IsValid() {
+		return nil, fmt.Errorf("template value not found: %s", name)
+	}
+	return v.Interface(), nil
+}
+
+func (x *expander) value(name string) (parser.SExp, error) {
+	v, err := x.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	return toSExp(v)
+}
+
+func (x *expander) spliced(name string) ([]parser.SExp, error) {
+	v, err := x.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if ss, ok := v.([]parser.SExp); ok {
+		return ss, nil
+	}
+	if v == nil {
+		return nil, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("template value for ,@%s should be a slice: %v", name, rv.Type())
+	}
+	res := make([]parser.SExp, rv.Len())
+	for i := range res {
+		if res[i], err = toSExp(rv.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func toSExp(v interface{}) (parser.SExp, error) {
+	if s, ok := v.(parser.SExp); ok {
+		return s, nil
+	}
+	buf, err := Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	// the nodes, not the bytes, to be walked and decoded
+	ss, perr := parser.ParseBytes(buf)
+	if perr != nil {
+		return nil, perr
+	}
+	if len(ss) != 1 {
+		return nil, fmt.Errorf("template value is encoded into %d forms: %s", len(ss), buf)
+	}
+	return ss[0], nil
+}
+
+// expand copies the node filling the placeholders. depth is the nesting
+// level of the backquotes; only the unquotes of the outermost one are
+// filled.
+func (x *expander) expand(s parser.SExp, depth int) (parser.SExp, error) {
+	switch s := s.(type) {
+	case *parser.SExpUnquote:
+		if depth > 1 {
+			inner, err := x.expand(s.Inner(), depth-1)
+			if err != nil {
+				return nil, err
+			}
+			if s.IsSplice() {
+				return parser.AstUnqs(inner), nil
+			}
+			return parser.AstUnq(inner), nil
+		}
+		if s.IsSplice() {
+			return nil, fmt.Errorf("template ,@ should be in a list: %s", s.ToSExpString())
+		}
+		sym, ok := s.Inner().(*parser.SExpSymbol)
+		if !ok {
+			return nil, fmt.Errorf("template placeholder should be a symbol: %s", s.ToSExpString())
+		}
+		return x.value(sym.Name())
+	case *parser.SExpQuasiQuoted:
+		inner, err := x.expand(s.Inner(), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		return parser.AstQq(inner), nil
+	case *parser.SExpQuoted:
+		inner, err := x.expand(s.Inner(), depth)
+		if err != nil {
+			return nil, err
+		}
+		if s.IsFunction() {
+			return parser.AstQf(inner), nil
+		}
+		return parser.AstQ(inner), nil
+	case *parser.SExpList:
+		elements, err := x.expandSeq(s.Elements(), depth)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) == 0 {
+			return parser.AstNil(), nil
+		}
+		return parser.AstList(elements), nil
+	case *parser.SExpListDot:
+		elements, err := x.expandSeq(s.Elements(), depth)
+		if err != nil {
+			return nil, err
+		}
+		last, err := x.expand(s.Last(), depth)
+		if err != nil {
+			return nil, err
+		}
+		return parser.AstDotlist(elements, last), nil
+	case *parser.SExpCons:
+		car, err := x.expandSeq([]parser.SExp{s.Car()}, depth)
+		if err != nil {
+			return nil, err
+		}
+		cdr, err := x.expand(s.Cdr(), depth)
+		if err != nil {
+			return nil, err
+		}
+		switch len(car) {
+		case 0:
+			return cdr, nil
+		case 1:
+			return parser.AstCons(car[0], cdr), nil
+		}
+		return parser.AstDotlist(car, cdr), nil
+	case *parser.SExpVector:
+		elements, err := x.expandSeq(s.Elements(), depth)
+		if err != nil {
+			return nil, err
+		}
+		return parser.AstVectorv(elements...), nil
+	}
+	return s, nil
+}
+
+func (x *expander) expandSeq(elements []parser.SExp, depth int) ([]parser.SExp, error) {
+	res := make([]parser.SExp, 0, len(elements))
+	for _, e := range elements {
+		if u, ok := e.(*parser.SExpUnquote); ok && u.IsSplice() && depth == 1 {
+			sym, ok := u.Inner().(*parser.SExpSymbol)
+			if !ok {
+				return nil, fmt.Errorf("template placeholder should be a symbol: %s", u.ToSExpString())
+			}
+			vs, err := x.spliced(sym.Name())
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, vs...)
+			continue
+		}
+		v, err := x.expand(e, depth)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, v)
+	}
+	return res, nil
+}
@@ -0,0 +1,86 @@
+package elrpc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kiwanami/go-elrpc/parser"
+)
+
+func testTemplate(t *testing.T, src string, values interface{}, expected string) {
+	tmpl, err := NewTemplate(src)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res, err := tmpl.ExpandString(values)
+	if err != nil {
+		t.Errorf("Template %s: %v", src, err)
+		return
+	}
+	if res != expected {
+		t.Errorf("Template %s:\n  expected: %s\n  returned: %s", src, expected, res)
+	}
+}
+
+func TestTemplate1(t *testing.T) {
+	m := map[string]interface{}{
+		"name":  `"); (kill-emacs`,
+		"items": []int{1, 2, 3},
+		"sym":   Symbol("foo"),
+		"form":  parser.AstListv(parser.AstSymbol("point")),
+		"empty": []string{},
+	}
+	testTemplate(t, "`(message \"Hello %s\" ,name)", m, `(message "Hello %s" "\"); (kill-emacs")`)
+	testTemplate(t, "(+ ,@items)", m, `(+ 1 2 3)`)
+	testTemplate(t, "`(list ',items ,@empty ,sym)", m, `(list '(1 2 3) foo)`)
+	testTemplate(t, "`(goto-char ,form)", m, `(goto-char (point))`)
+	testTemplate(t, "`[a ,@items]", m, `[a 1 2 3]`)
+	testTemplate(t, "`(a . ,sym)", m, `(a . foo)`)
+	testTemplate(t, "`(defmacro m () `(list ,,sym ,x))", m, "(defmacro m nil `(list ,foo ,x))")
+
+	type args struct {
+		Name  string
+		Count int
+	}
+	testTemplate(t, "`(insert (make-string ,count ?a) ,name)", &args{"bob", 3}, `(insert (make-string 3 ?a) "bob")`)
+
+	for _, src := range []string{"`(a ,b)", "`(a ,(b))", "`,@items", "`(a ,@name)"} {
+		tmpl, err := NewTemplate(src)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if _, err := tmpl.Expand(m); err == nil {
+			t.Errorf("Expected an error: %s", src)
+		}
+	}
+	if _, err := NewTemplate("(a) (b)"); err == nil {
+		t.Errorf("Expected an error for two forms")
+	}
+}
+
+func TestTemplateExpandedNodes(t *testing.T) {
+	tmpl, err := NewTemplate("`(message ,name ,@items)")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	res, err := tmpl.Expand(map[string]interface{}{"name": "x", "items": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	v := res.ToValueWith(&parser.ValueOptions{PreserveSymbols: true})
+	expected := []interface{}{Symbol("message"), "x", 1, 2}
+	if !reflect.DeepEqual(v, expected) {
+		t.Errorf("Unexpected value: %#v", v)
+	}
+	var atoms []string
+	parser.Walk(res, func(s parser.SExp) bool {
+		if len(parser.Children(s)) == 0 {
+			atoms = append(atoms, s.ToSExpString())
+		}
+		return true
+	}, nil)
+	if s := strings.Join(atoms, " "); s != `message "x" 1 2` {
+		t.Errorf("Unexpected atoms: %s", s)
+	}
+}